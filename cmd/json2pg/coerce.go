@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/pkg/errors"
+)
+
+// timestampLayouts are the string formats accepted for timestamp
+// columns, tried in order.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// dateLayouts are the string formats accepted for date columns.
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+}
+
+// timeLayouts are the string formats accepted for time columns.
+var timeLayouts = []string{
+	"15:04:05",
+	"15:04",
+	"15:04:05Z07:00",
+	"15:04Z07:00",
+}
+
+// integerTypes and floatTypes hold the data types of numeric columns
+// besides numeric itself, textTypes those of string columns.
+var (
+	integerTypes = map[string]bool{"smallint": true, "integer": true, "bigint": true}
+	floatTypes   = map[string]bool{"real": true, "double precision": true}
+	textTypes    = map[string]bool{"text": true, "character varying": true, "character": true, "citext": true}
+	jsonTypes    = map[string]bool{"json": true, "jsonb": true}
+	// objectTypes holds the types JSON objects are converted to besides
+	// composite and range types
+	objectTypes = map[string]bool{"json": true, "jsonb": true, "hstore": true, "geometry": true, "geography": true}
+)
+
+// hintTypes maps the kinds of -type-hint to the column type whose
+// conversion they select.
+var hintTypes = map[string]string{
+	"json":      "jsonb",
+	"timestamp": "timestamp with time zone",
+	"date":      "date",
+	"array":     "text[]",
+	"uuid":      "uuid",
+	"bool":      "boolean",
+	"numeric":   "numeric",
+	"integer":   "bigint",
+	"float":     "double precision",
+	"text":      "text",
+}
+
+// coerce converts a decoded JSON value into a value pgx can encode for a
+// column of type typ.
+func coerce(typ string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if n, ok := v.(json.Number); ok {
+		return coerceNumber(typ, n)
+	}
+	kind := reflect.TypeOf(v).Kind()
+	switch {
+	// handle string -> timestamp
+	case kind == reflect.String && strings.HasPrefix(typ, "timestamp"):
+		return parseTime(v.(string), timestampLayouts)
+	// handle string -> date
+	case kind == reflect.String && typ == "date":
+		return parseTime(v.(string), dateLayouts)
+	// handle string -> time, postgres parses the validated string itself
+	case kind == reflect.String && strings.HasPrefix(typ, "time "):
+		if _, err := parseTime(v.(string), timeLayouts); err != nil {
+			return nil, err
+		}
+		return v, nil
+	// handle ISO 8601 duration -> interval, postgres parses other strings
+	case kind == reflect.String && typ == "interval" && strings.HasPrefix(v.(string), "P"):
+		return parseInterval(v.(string))
+	// handle string -> number
+	case kind == reflect.String && integerTypes[typ]:
+		return toInteger(typ, strings.TrimSpace(v.(string)))
+	case kind == reflect.String && floatTypes[typ]:
+		return strconv.ParseFloat(strings.TrimSpace(v.(string)), 64)
+	case kind == reflect.String && typ == "numeric":
+		return toNumeric(strings.TrimSpace(v.(string)))
+	// handle string -> boolean
+	case kind == reflect.String && typ == "boolean":
+		return parseBool(v.(string))
+	// handle base64 string -> bytea
+	case kind == reflect.String && typ == "bytea":
+		b, err := base64.StdEncoding.DecodeString(v.(string))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid base64")
+		}
+		return b, nil
+	// handle string -> network address
+	case kind == reflect.String && (typ == "inet" || typ == "cidr"):
+		return parseNetwork(v.(string), typ == "cidr")
+	// handle string -> uuid
+	case kind == reflect.String && typ == "uuid":
+		return parseUUID(v.(string))
+	// handle object or range literal -> range
+	case (kind == reflect.Map || kind == reflect.String) && rangeTypes[typ].value != nil:
+		return toRange(rangeTypes[typ], v)
+	// handle GeoJSON object -> geometry
+	case kind == reflect.Map && (typ == "geometry" || typ == "geography"):
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode GeoJSON")
+		}
+		return geoJSON(b), nil
+	// handle object -> hstore
+	case kind == reflect.Map && typ == "hstore":
+		return toHstore(v.(map[string]interface{}))
+	// handle array -> postgres array
+	case kind == reflect.Slice && strings.HasSuffix(typ, "[]"):
+		return toArray(strings.TrimSuffix(typ, "[]"), v.([]interface{}))
+	// handle json/jsonb
+	case kind == reflect.Map || kind == reflect.Slice:
+		if *noAutoJSON && !jsonTypes[typ] {
+			return nil, errors.Errorf("JSON %s for a column of type %s, only json and jsonb columns take them with -no-auto-json", jsonType(v), typ)
+		}
+		b := bytes.NewBuffer(nil)
+		if err := json.NewEncoder(b).Encode(v); err != nil {
+			return nil, errors.Wrap(err, "failed to encode json")
+		}
+		return b.String(), nil
+	}
+	return v, nil
+}
+
+// coerceNumber converts a JSON number for a column of type typ without
+// going through float64 where that would lose precision.
+func coerceNumber(typ string, n json.Number) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(typ, "timestamp"):
+		return epoch(n)
+	case typ == "date":
+		// the calendar day in -timezone, or in UTC by default
+		t, err := epoch(n)
+		if timeZone == nil {
+			t = t.UTC()
+		}
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), err
+	case integerTypes[typ]:
+		return toInteger(typ, n.String())
+	case floatTypes[typ]:
+		return n.Float64()
+	case typ == "numeric":
+		return toNumeric(n.String())
+	case typ == "boolean":
+		return parseBool(n.String())
+	case typ == "interval":
+		f, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return &pgtype.Interval{Microseconds: int64(math.Round(f * 1e6)), Status: pgtype.Present}, nil
+	}
+	return n.String(), nil
+}
+
+// integerBits holds the width of the integer column types.
+var integerBits = map[string]uint{"smallint": 16, "integer": 32, "bigint": 64}
+
+// integerElements maps the element types of integer arrays to the
+// column type of the same width.
+var integerElements = map[string]string{"int2": "smallint", "int4": "integer", "int8": "bigint"}
+
+// toInteger parses s for an integer column of type typ. Numbers with a
+// fraction, which postgres would reject or which would need rounding,
+// and numbers too large for the column are an error; integral numbers
+// written like 3.0 or 1e3 are accepted.
+func toInteger(typ, s string) (int64, error) {
+	bits := integerBits[typ]
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if bits < 64 && (i < -1<<(bits-1) || i >= 1<<(bits-1)) {
+			return 0, errors.Errorf("%s is out of range for %s", s, typ)
+		}
+		return i, nil
+	}
+	f, _, err := big.ParseFloat(s, 10, 256, big.ToNearestEven)
+	if err != nil {
+		return 0, errors.Errorf("%q is not a number", s)
+	}
+	if !f.IsInt() {
+		return 0, errors.Errorf("%s is not an integer, as needed by the %s column", s, typ)
+	}
+	limit := new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), bits-1))
+	if f.Cmp(limit) >= 0 || f.Cmp(limit.Neg(limit)) < 0 {
+		return 0, errors.Errorf("%s is out of range for %s", s, typ)
+	}
+	i, _ := f.Int64()
+	return i, nil
+}
+
+// boolStrings maps the accepted spellings of booleans to their value.
+var boolStrings = map[string]bool{
+	"true": true, "t": true, "yes": true, "y": true, "on": true, "1": true,
+	"false": false, "f": false, "no": false, "n": false, "off": false, "0": false,
+}
+
+// geoJSON is a GeoJSON geometry, inserted through ST_GeomFromGeoJSON.
+type geoJSON string
+
+func (g geoJSON) Value() (driver.Value, error) {
+	return string(g), nil
+}
+
+// toHstore converts an object to an hstore. Values other than strings
+// are stored as their JSON text, nulls stay NULL.
+func toHstore(m map[string]interface{}) (*pgtype.Hstore, error) {
+	h := &pgtype.Hstore{Map: make(map[string]pgtype.Text, len(m)), Status: pgtype.Present}
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			h.Map[k] = pgtype.Text{Status: pgtype.Null}
+		case string:
+			h.Map[k] = pgtype.Text{String: v, Status: pgtype.Present}
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to encode hstore value of %s", k)
+			}
+			h.Map[k] = pgtype.Text{String: string(b), Status: pgtype.Present}
+		}
+	}
+	return h, nil
+}
+
+// isoDuration matches ISO 8601 durations like P1Y2M3DT4H5M6.5S.
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseInterval converts an ISO 8601 duration to an interval.
+func parseInterval(s string) (*pgtype.Interval, error) {
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return nil, errors.Errorf("invalid duration %q", s)
+	}
+	n := make([]int64, 7)
+	for i := 1; i < 7; i++ {
+		if m[i] != "" {
+			n[i], _ = strconv.ParseInt(m[i], 10, 64)
+		}
+	}
+	var sec float64
+	if m[7] != "" {
+		sec, _ = strconv.ParseFloat(m[7], 64)
+	}
+	return &pgtype.Interval{
+		Months:       int32(n[1]*12 + n[2]),
+		Days:         int32(n[3]*7 + n[4]),
+		Microseconds: (n[5]*3600+n[6]*60)*1e6 + int64(math.Round(sec*1e6)),
+		Status:       pgtype.Present,
+	}, nil
+}
+
+// parseNetwork validates an IP address, optionally followed by a prefix
+// length. A cidr must not have bits set right of the prefix. The address
+// is returned as given, as Go would turn IPv4-mapped IPv6 addresses into
+// IPv4 ones.
+func parseNetwork(s string, cidr bool) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		if net.ParseIP(s) == nil {
+			return "", errors.Errorf("invalid address %q", s)
+		}
+		return s, nil
+	}
+	ip, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", errors.Errorf("invalid network %q", s)
+	}
+	if cidr && !ip.Equal(network.IP) {
+		return "", errors.Errorf("network %q has bits set right of the prefix", s)
+	}
+	return s, nil
+}
+
+// parseUUID validates a UUID in the canonical dashed form or as 32 hex
+// digits and returns it in canonical form.
+func parseUUID(s string) (string, error) {
+	digits := strings.TrimSpace(s)
+	if len(digits) == 36 && digits[8] == '-' && digits[13] == '-' && digits[18] == '-' && digits[23] == '-' {
+		digits = strings.Replace(digits, "-", "", -1)
+	}
+	b, err := hex.DecodeString(digits)
+	if err != nil || len(b) != 16 {
+		return "", errors.Errorf("invalid uuid %q", s)
+	}
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// parseBool converts the common textual representations of a boolean,
+// ignoring case and surrounding spaces.
+func parseBool(s string) (bool, error) {
+	b, ok := boolStrings[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return false, errors.Errorf("invalid boolean %q", s)
+	}
+	return b, nil
+}
+
+// epochUnits maps the -epoch-unit names to their duration.
+var epochUnits = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// timeZone is the -timezone location, nil for the default of naive
+// strings read as UTC and epoch numbers in the local zone.
+var timeZone *time.Location
+
+// epoch converts a number of -epoch-unit units since the unix epoch to
+// a time in -timezone, which sets the wall clock time stored in columns
+// without a time zone.
+func epoch(n json.Number) (time.Time, error) {
+	t, err := epochTime(n)
+	if err == nil && timeZone != nil {
+		t = t.In(timeZone)
+	}
+	return t, err
+}
+
+func epochTime(n json.Number) (time.Time, error) {
+	perSecond := int64(time.Second / epochUnits[*epochUnit])
+	if i, err := n.Int64(); err == nil {
+		return time.Unix(i/perSecond, i%perSecond*(int64(time.Second)/perSecond)), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec := math.Floor(f / float64(perSecond))
+	return time.Unix(int64(sec), int64((f/float64(perSecond)-sec)*1e9)), nil
+}
+
+// maxNumericDigits bounds the exponent of numerics.
+const maxNumericDigits = 131072
+
+// numericPattern matches decimal numbers, with an optional exponent as
+// in 1.5e3.
+var numericPattern = regexp.MustCompile(`^([+-]?)([0-9]*)(?:\.([0-9]*))?(?:[eE]([+-]?[0-9]+))?$`)
+
+// toNumeric parses s into an exact numeric value, the digits without the
+// decimal point becoming the integer and the exponent shifted by the
+// number of decimals.
+func toNumeric(s string) (*pgtype.Numeric, error) {
+	m := numericPattern.FindStringSubmatch(s)
+	if m == nil || m[2]+m[3] == "" {
+		return nil, errors.Errorf("%q is not a number", s)
+	}
+	i, ok := new(big.Int).SetString(m[1]+m[2]+m[3], 10)
+	if !ok {
+		return nil, errors.Errorf("%q is not a number", s)
+	}
+	exp := -int64(len(m[3]))
+	if m[4] != "" {
+		e, err := strconv.ParseInt(m[4], 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("exponent of %s is out of range", s)
+		}
+		exp += e
+	}
+	// postgres keeps up to 131072 digits before the decimal point and
+	// 16383 after it, larger exponents would only blow up the encoding
+	if exp > maxNumericDigits || exp < -maxNumericDigits-int64(len(m[2]+m[3])) {
+		return nil, errors.Errorf("%s is out of range for numeric", s)
+	}
+	return &pgtype.Numeric{Int: i, Exp: int32(exp), Status: pgtype.Present}, nil
+}
+
+// roundNumeric rounds n half away from zero to scale decimals, the way
+// postgres stores it in a numeric column declared with that scale.
+func roundNumeric(n *pgtype.Numeric, scale int) *pgtype.Numeric {
+	drop := -scale - int(n.Exp)
+	if n.Status != pgtype.Present || drop <= 0 {
+		return n
+	}
+	if drop > len(n.Int.String()) {
+		// less than half a unit of the last decimal kept
+		return &pgtype.Numeric{Int: new(big.Int), Exp: int32(-scale), Status: pgtype.Present}
+	}
+	div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(drop)), nil)
+	q, r := new(big.Int).QuoRem(n.Int, div, new(big.Int))
+	// round up if twice the remainder reaches the divisor
+	if r.Abs(r).Lsh(r, 1).Cmp(div) >= 0 {
+		if n.Int.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return &pgtype.Numeric{Int: q, Exp: int32(-scale), Status: pgtype.Present}
+}
+
+// arrayTypes maps array element types to the pgtype arrays used to send
+// them, so the elements are encoded with the right type by both the
+// simple and the binary protocol. Arrays of other element types are sent
+// as the literal of a text array, which postgres parses for the column.
+var arrayTypes = map[string]reflect.Type{
+	"int2":        reflect.TypeOf(pgtype.Int2Array{}),
+	"int4":        reflect.TypeOf(pgtype.Int4Array{}),
+	"int8":        reflect.TypeOf(pgtype.Int8Array{}),
+	"float4":      reflect.TypeOf(pgtype.Float4Array{}),
+	"float8":      reflect.TypeOf(pgtype.Float8Array{}),
+	"numeric":     reflect.TypeOf(pgtype.NumericArray{}),
+	"bool":        reflect.TypeOf(pgtype.BoolArray{}),
+	"text":        reflect.TypeOf(textArray{}),
+	"varchar":     reflect.TypeOf(varcharArray{}),
+	"bpchar":      reflect.TypeOf(pgtype.BPCharArray{}),
+	"uuid":        reflect.TypeOf(pgtype.UUIDArray{}),
+	"date":        reflect.TypeOf(pgtype.DateArray{}),
+	"timestamp":   reflect.TypeOf(pgtype.TimestampArray{}),
+	"timestamptz": reflect.TypeOf(pgtype.TimestamptzArray{}),
+}
+
+// toArray converts items into a one dimensional array of elem.
+// Element types without a matching pgtype array are sent as an array
+// literal, as a text array would be refused for them by the binary
+// protocol.
+func toArray(elem string, items []interface{}) (interface{}, error) {
+	t, ok := arrayTypes[elem]
+	if !ok {
+		t = arrayTypes["text"]
+	}
+	a := reflect.New(t).Elem()
+	elements := reflect.MakeSlice(a.FieldByName("Elements").Type(), len(items), len(items))
+	for i, item := range items {
+		// the pgtype elements parse numbers themselves, only epoch
+		// numbers and numerics with an exponent need converting
+		n, isNumber := item.(json.Number)
+		var v interface{}
+		if typ, isInteger := integerElements[elem]; isNumber && isInteger {
+			var err error
+			if v, err = toInteger(typ, n.String()); err != nil {
+				return nil, errors.Wrapf(err, "element %d", i)
+			}
+		} else if isNumber && !strings.HasPrefix(elem, "timestamp") && elem != "date" && elem != "numeric" {
+			v = n.String()
+		} else {
+			var err error
+			if v, err = coerce(elem, item); err != nil {
+				return nil, errors.Wrapf(err, "element %d", i)
+			}
+		}
+		if b, isBool := v.(bool); isBool && !ok {
+			v = strconv.FormatBool(b)
+		}
+		e := elements.Index(i)
+		if pv := reflect.ValueOf(v); pv.Kind() == reflect.Ptr && pv.Elem().Type() == e.Type() {
+			// numerics are returned as pgtype values already
+			e.Set(pv.Elem())
+		} else if err := e.Addr().Interface().(pgtype.Value).Set(v); err != nil {
+			return nil, errors.Wrapf(err, "element %d", i)
+		}
+	}
+	a.FieldByName("Elements").Set(elements)
+	// an empty array has no dimensions, pgtype sends one of length 0 as
+	// NULL
+	if len(items) > 0 {
+		a.FieldByName("Dimensions").Set(reflect.ValueOf([]pgtype.ArrayDimension{{Length: int32(len(items)), LowerBound: 1}}))
+	}
+	a.FieldByName("Status").Set(reflect.ValueOf(pgtype.Present))
+	if !ok {
+		b, err := a.Addr().Interface().(pgtype.TextEncoder).EncodeText(nil, nil)
+		return string(b), err
+	}
+	return a.Addr().Interface(), nil
+}
+
+// textArray and varcharArray fix the text form of their pgtype arrays,
+// used by the simple protocol and -freeze, which writes null elements
+// as the string "NULL".
+type (
+	textArray    struct{ pgtype.TextArray }
+	varcharArray struct{ pgtype.VarcharArray }
+)
+
+func (a *textArray) EncodeText(ci *pgtype.ConnInfo, buf []byte) ([]byte, error) {
+	return encodeStringArray(buf, a.Status, a.Elements)
+}
+
+func (a *textArray) Value() (driver.Value, error) {
+	return textDriverValue(a)
+}
+
+func (a *varcharArray) EncodeText(ci *pgtype.ConnInfo, buf []byte) ([]byte, error) {
+	elements := make([]pgtype.Text, len(a.Elements))
+	for i, e := range a.Elements {
+		elements[i] = pgtype.Text(e)
+	}
+	return encodeStringArray(buf, a.Status, elements)
+}
+
+func (a *varcharArray) Value() (driver.Value, error) {
+	return textDriverValue(a)
+}
+
+// arrayElementEscaper escapes the characters of a quoted array element.
+var arrayElementEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// encodeStringArray appends the literal of a one dimensional array of
+// strings to buf, or returns nil for NULL.
+func encodeStringArray(buf []byte, status pgtype.Status, elements []pgtype.Text) ([]byte, error) {
+	switch status {
+	case pgtype.Null:
+		return nil, nil
+	case pgtype.Undefined:
+		return nil, errors.New("cannot encode status undefined")
+	}
+	buf = append(buf, '{')
+	for i, e := range elements {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if e.Status == pgtype.Present {
+			// quoted always, postgres trims any white space around
+			// unquoted elements
+			buf = append(buf, '"')
+			buf = append(buf, arrayElementEscaper.Replace(e.String)...)
+			buf = append(buf, '"')
+		} else {
+			buf = append(buf, "NULL"...)
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+// textDriverValue returns the text form of v for database/sql, which
+// pgx uses to send values with the simple protocol.
+func textDriverValue(v pgtype.TextEncoder) (driver.Value, error) {
+	buf, err := v.EncodeText(nil, nil)
+	if err != nil || buf == nil {
+		return nil, err
+	}
+	return string(buf), nil
+}
+
+// parseTime parses s with the first matching layout, reading times
+// without an offset in -timezone.
+func parseTime(s string, layouts []string) (time.Time, error) {
+	loc := time.UTC
+	if timeZone != nil {
+		loc = timeZone
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("unrecognized time format %q", s)
+}
+
+// checkEnum checks that a string is one of the labels of an enum. Other
+// values are left to the server.
+func checkEnum(labels []string, v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return nil
+	}
+	for _, l := range labels {
+		if s == l {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid value %q, expected one of %s", s, strings.Join(labels, ", "))
+}
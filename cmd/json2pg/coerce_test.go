@@ -0,0 +1,523 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// text returns the text representation of a coerced value.
+func text(t *testing.T, v interface{}) string {
+	t.Helper()
+	s, null, err := textValue(pgtype.NewConnInfo(), v)
+	if err != nil {
+		t.Fatalf("textValue(%#v): %v", v, err)
+	}
+	if null {
+		return "NULL"
+	}
+	return s
+}
+
+// mustCoerce returns the coerced value of v.
+func mustCoerce(t *testing.T, typ string, v interface{}) interface{} {
+	t.Helper()
+	c, err := coerce(typ, v)
+	if err != nil {
+		t.Fatalf("coerce(%s, %v): %v", typ, v, err)
+	}
+	return c
+}
+
+func TestToNumeric(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "12", want: "12e0"},
+		{in: "-12.50", want: "-1250e-2"},
+		{in: "1e3", want: "1e3"},
+		{in: "1.5E-2", want: "15e-3"},
+		{in: "+.5", want: "5e-1"},
+		{in: "5.", want: "5e0"},
+		{in: "123456789012345678901234567890.5", want: "1234567890123456789012345678905e-1"},
+		{in: "", err: true},
+		{in: ".", err: true},
+		{in: "1e", err: true},
+		{in: "abc", err: true},
+		{in: "1,5", err: true},
+		{in: "1e1000000", err: true},
+		{in: "1e-99999999999", err: true},
+	}
+	for _, tt := range tests {
+		n, err := toNumeric(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("toNumeric(%q): expected an error, got %v", tt.in, text(t, n))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("toNumeric(%q): %v", tt.in, err)
+			continue
+		}
+		if got := text(t, n); got != tt.want {
+			t.Errorf("toNumeric(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRoundNumeric(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		want  string
+	}{
+		{"1.005", 2, "101e-2"},
+		{"-1.005", 2, "-101e-2"},
+		{"1.004", 2, "100e-2"},
+		{"1.5", 2, "15e-1"},
+		{"1e-100000", 2, "0e-2"},
+	}
+	for _, tt := range tests {
+		n, err := toNumeric(tt.in)
+		if err != nil {
+			t.Fatalf("toNumeric(%q): %v", tt.in, err)
+		}
+		if got := text(t, roundNumeric(n, tt.scale)); got != tt.want {
+			t.Errorf("roundNumeric(%s, %d) = %s, want %s", tt.in, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestCoerceNumericExponent(t *testing.T) {
+	tests := []struct {
+		typ  string
+		v    interface{}
+		want string
+	}{
+		{"numeric", json.Number("1e3"), "1e3"},
+		{"numeric", "1.5E-2", "15e-3"},
+		{"numeric[]", []interface{}{json.Number("1e3"), "2.5", nil}, "{1e3,25e-1,NULL}"},
+		{"numrange", map[string]interface{}{"lower": json.Number("1e2"), "upper": json.Number("2E3")}, "[1e2,2e3)"},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, tt.v)
+		if err != nil {
+			t.Errorf("coerce(%s, %v): %v", tt.typ, tt.v, err)
+			continue
+		}
+		if got := text(t, v); got != tt.want {
+			t.Errorf("coerce(%s, %v) = %s, want %s", tt.typ, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestCoerceStringArrayNull(t *testing.T) {
+	tests := []struct {
+		typ  string
+		v    []interface{}
+		want string
+	}{
+		{"text[]", []interface{}{"a", nil}, `{"a",NULL}`},
+		{"varchar[]", []interface{}{"a", nil}, `{"a",NULL}`},
+		{"text[]", []interface{}{"NULL", "\tb", `"c\`}, "{\"NULL\",\"\tb\",\"\\\"c\\\\\"}"},
+		{"varchar[]", []interface{}{"null", ""}, `{"null",""}`},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, tt.v)
+		if err != nil {
+			t.Errorf("coerce(%s, %v): %v", tt.typ, tt.v, err)
+			continue
+		}
+		if got := text(t, v); got != tt.want {
+			t.Errorf("coerce(%s, %v) = %s, want %s", tt.typ, tt.v, got, tt.want)
+		}
+		dv, err := v.(driver.Valuer).Value()
+		if err != nil {
+			t.Errorf("coerce(%s, %v).Value(): %v", tt.typ, tt.v, err)
+		} else if dv != tt.want {
+			t.Errorf("coerce(%s, %v).Value() = %v, want %s", tt.typ, tt.v, dv, tt.want)
+		}
+	}
+}
+
+func TestToInteger(t *testing.T) {
+	tests := []struct {
+		typ  string
+		in   string
+		want int64
+		err  bool
+	}{
+		{"integer", "42", 42, false},
+		{"integer", "-7", -7, false},
+		{"bigint", "9223372036854775807", 9223372036854775807, false},
+		{"bigint", "-9223372036854775808", -9223372036854775808, false},
+		{"bigint", "9223372036854775808", 0, true},
+		{"integer", "2147483647", 2147483647, false},
+		{"integer", "2147483648", 0, true},
+		{"integer", "-2147483648", -2147483648, false},
+		{"integer", "-2147483649", 0, true},
+		{"smallint", "32767", 32767, false},
+		{"smallint", "32768", 0, true},
+		{"smallint", "-32768", -32768, false},
+		{"integer", "3.0", 3, false},
+		{"integer", "1e3", 1000, false},
+		{"bigint", "9.2e18", 9200000000000000000, false},
+		{"bigint", "1e19", 0, true},
+		{"smallint", "4e4", 0, true},
+		{"integer", "3.5", 0, true},
+		{"integer", "1e-1", 0, true},
+		{"integer", "abc", 0, true},
+		{"integer", "", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := toInteger(tt.typ, tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("toInteger(%s, %q) = %d, want an error", tt.typ, tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("toInteger(%s, %q): %v", tt.typ, tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("toInteger(%s, %q) = %d, want %d", tt.typ, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCoerceIntegerArray(t *testing.T) {
+	if got := text(t, mustCoerce(t, "int2[]", []interface{}{json.Number("1e2"), json.Number("3.0")})); got != "{100,3}" {
+		t.Errorf("coerce(int2[], [1e2 3.0]) = %s, want {100,3}", got)
+	}
+	if v, err := coerce("int2[]", []interface{}{json.Number("1e5")}); err == nil {
+		t.Errorf("coerce(int2[], [1e5]) = %s, want an error", text(t, v))
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		in     string
+		months int32
+		days   int32
+		micros int64
+		err    bool
+	}{
+		{"P1Y2M3DT4H5M6.5S", 14, 3, (4*3600+5*60)*1e6 + 6500000, false},
+		{"P2W", 0, 14, 0, false},
+		{"P1W2D", 0, 9, 0, false},
+		{"PT36H", 0, 0, 36 * 3600 * 1e6, false},
+		{"PT0.000001S", 0, 0, 1, false},
+		{"P0D", 0, 0, 0, false},
+		{"P", 0, 0, 0, true},
+		{"P1DT", 0, 0, 0, true},
+		{"PT", 0, 0, 0, true},
+		{"P1H", 0, 0, 0, true},
+		{"P-1D", 0, 0, 0, true},
+		{"1 day", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseInterval(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseInterval(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseInterval(%q): %v", tt.in, err)
+			continue
+		}
+		if got.Months != tt.months || got.Days != tt.days || got.Microseconds != tt.micros || got.Status != pgtype.Present {
+			t.Errorf("parseInterval(%q) = %+v, want %d months, %d days, %d µs", tt.in, got, tt.months, tt.days, tt.micros)
+		}
+	}
+}
+
+func TestCoerceInterval(t *testing.T) {
+	if v, err := coerce("interval", json.Number("1.5")); err != nil || v.(*pgtype.Interval).Microseconds != 1500000 {
+		t.Errorf("coerce(interval, 1.5) = %v, %v", v, err)
+	}
+	if v, err := coerce("interval", "1 day"); err != nil || v != "1 day" {
+		t.Errorf("coerce(interval, 1 day) = %v, %v", v, err)
+	}
+}
+
+func TestCoerceTime(t *testing.T) {
+	tests := []struct {
+		typ  string
+		in   string
+		want time.Time
+	}{
+		{"date", "2019-03-04", time.Date(2019, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"timestamp with time zone", "2019-03-04T05:06:07Z", time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{"timestamp with time zone", "2019-03-04T05:06:07+02:00", time.Date(2019, 3, 4, 3, 6, 7, 0, time.UTC)},
+		{"timestamp without time zone", "2019-03-04 05:06:07", time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, tt.in)
+		if err != nil {
+			t.Errorf("coerce(%s, %q): %v", tt.typ, tt.in, err)
+			continue
+		}
+		if got, ok := v.(time.Time); !ok || !got.Equal(tt.want) {
+			t.Errorf("coerce(%s, %q) = %v, want %v", tt.typ, tt.in, v, tt.want)
+		}
+	}
+	if v, err := coerce("time without time zone", "05:06:07"); err != nil || v != "05:06:07" {
+		t.Errorf("coerce(time without time zone, 05:06:07) = %v, %v", v, err)
+	}
+	for _, tt := range []struct{ typ, in string }{
+		{"date", "04/03/2019x"},
+		{"timestamp with time zone", "yesterday"},
+		{"time without time zone", "25:00"},
+	} {
+		if v, err := coerce(tt.typ, tt.in); err == nil {
+			t.Errorf("coerce(%s, %q) = %v, want an error", tt.typ, tt.in, v)
+		}
+	}
+}
+
+func TestCoerceNumber(t *testing.T) {
+	tests := []struct {
+		typ  string
+		n    string
+		want interface{}
+	}{
+		{"bigint", "9223372036854775807", int64(9223372036854775807)},
+		{"integer", "-12", int64(-12)},
+		{"double precision", "2.25", 2.25},
+		{"text", "12345678901234567890.5", "12345678901234567890.5"},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, json.Number(tt.n))
+		if err != nil {
+			t.Errorf("coerce(%s, %s): %v", tt.typ, tt.n, err)
+		} else if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("coerce(%s, %s) = %#v, want %#v", tt.typ, tt.n, v, tt.want)
+		}
+	}
+	if got := text(t, mustCoerce(t, "numeric", json.Number("12345678901234567890.5"))); got != "123456789012345678905e-1" {
+		t.Errorf("coerce(numeric, 12345678901234567890.5) = %s", got)
+	}
+}
+
+func TestCoerceFloat(t *testing.T) {
+	for _, typ := range []string{"double precision", "real"} {
+		if v, err := coerce(typ, " 1.5 "); err != nil || v != 1.5 {
+			t.Errorf("coerce(%s, 1.5) = %v, %v", typ, v, err)
+		}
+		if v, err := coerce(typ, "x"); err == nil {
+			t.Errorf("coerce(%s, x) = %v, want an error", typ, v)
+		}
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for in, want := range map[string]bool{"Yes": true, " off ": false, "T": true, "0": false, "on": true, "n": false} {
+		if got, err := parseBool(in); err != nil || got != want {
+			t.Errorf("parseBool(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "maybe", "2"} {
+		if _, err := parseBool(in); err == nil {
+			t.Errorf("parseBool(%q): want an error", in)
+		}
+	}
+	if v, err := coerce("boolean", json.Number("1")); err != nil || v != true {
+		t.Errorf("coerce(boolean, 1) = %v, %v", v, err)
+	}
+}
+
+func TestParseUUID(t *testing.T) {
+	const want = "01234567-89ab-cdef-0123-456789abcdef"
+	for _, in := range []string{want, "0123456789ABCDEF0123456789abcdef", " 01234567-89AB-CDEF-0123-456789ABCDEF "} {
+		if got, err := parseUUID(in); err != nil || got != want {
+			t.Errorf("parseUUID(%q) = %s, %v, want %s", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0123456789abcdef", "0123456789abcdef0123456789abcdeg", "0123-4567-89ab-cdef-0123456789abcdef"} {
+		if got, err := parseUUID(in); err == nil {
+			t.Errorf("parseUUID(%q) = %s, want an error", in, got)
+		}
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		in   string
+		cidr bool
+		want string
+		err  bool
+	}{
+		{in: " 10.0.0.1/8 ", want: "10.0.0.1/8"},
+		{in: "10.0.0.0/8", cidr: true, want: "10.0.0.0/8"},
+		{in: "::ffff:10.0.0.1", want: "::ffff:10.0.0.1"},
+		{in: "2001:db8::/32", cidr: true, want: "2001:db8::/32"},
+		{in: "10.0.0.1/8", cidr: true, err: true},
+		{in: "10.0.0.256", err: true},
+		{in: "10.0.0.1/33", err: true},
+		{in: "host", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNetwork(tt.in, tt.cidr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseNetwork(%q, %v) = %s, want an error", tt.in, tt.cidr, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("parseNetwork(%q, %v) = %s, %v, want %s", tt.in, tt.cidr, got, err, tt.want)
+		}
+	}
+}
+
+func TestToHstore(t *testing.T) {
+	h, err := toHstore(map[string]interface{}{"a": "x", "n": nil, "o": map[string]interface{}{"b": json.Number("1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]pgtype.Text{
+		"a": {String: "x", Status: pgtype.Present},
+		"n": {Status: pgtype.Null},
+		"o": {String: `{"b":1}`, Status: pgtype.Present},
+	}
+	if !reflect.DeepEqual(h.Map, want) || h.Status != pgtype.Present {
+		t.Errorf("toHstore = %v, want %v", h.Map, want)
+	}
+}
+
+func TestCoerceBytea(t *testing.T) {
+	if v, err := coerce("bytea", "aGk="); err != nil || !reflect.DeepEqual(v, []byte("hi")) {
+		t.Errorf("coerce(bytea, aGk=) = %v, %v", v, err)
+	}
+	if v, err := coerce("bytea", "not base64!"); err == nil {
+		t.Errorf("coerce(bytea, not base64!) = %v, want an error", v)
+	}
+}
+
+func TestToArray(t *testing.T) {
+	tests := []struct {
+		typ  string
+		v    []interface{}
+		want string
+	}{
+		{"int4[]", []interface{}{json.Number("1"), "2", nil}, "{1,2,NULL}"},
+		{"int8[]", []interface{}{json.Number("9007199254740993")}, "{9007199254740993}"},
+		{"bool[]", []interface{}{true, false}, "{t,f}"},
+		{"float8[]", []interface{}{json.Number("1.5"), "2"}, "{1.5,2}"},
+		{"uuid[]", []interface{}{"01234567-89ab-cdef-0123-456789abcdef"}, "{01234567-89ab-cdef-0123-456789abcdef}"},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, tt.v)
+		if err != nil {
+			t.Errorf("coerce(%s, %v): %v", tt.typ, tt.v, err)
+			continue
+		}
+		if got := text(t, v); got != tt.want {
+			t.Errorf("coerce(%s, %v) = %s, want %s", tt.typ, tt.v, got, tt.want)
+		}
+	}
+	if v, err := coerce("int4[]", []interface{}{"1", "x"}); err == nil {
+		t.Errorf("coerce(int4[], [1 x]) = %s, want an error", text(t, v))
+	}
+}
+
+func TestCoerceJSON(t *testing.T) {
+	obj := map[string]interface{}{"a": json.Number("1")}
+	for _, typ := range []string{"jsonb", "text"} {
+		if v, err := coerce(typ, obj); err != nil || v != "{\"a\":1}\n" {
+			t.Errorf("coerce(%s, %v) = %q, %v", typ, obj, v, err)
+		}
+	}
+	defer func(b bool) { *noAutoJSON = b }(*noAutoJSON)
+	*noAutoJSON = true
+	if v, err := coerce("jsonb", []interface{}{"a"}); err != nil || v != "[\"a\"]\n" {
+		t.Errorf("coerce(jsonb, [a]) with -no-auto-json = %q, %v", v, err)
+	}
+	if v, err := coerce("text", obj); err == nil {
+		t.Errorf("coerce(text, %v) with -no-auto-json = %q, want an error", obj, v)
+	}
+}
+
+func TestCoerceArrayLiteral(t *testing.T) {
+	tests := []struct {
+		typ  string
+		v    []interface{}
+		want string
+	}{
+		{"inet[]", []interface{}{"::1", nil}, `{"::1",NULL}`},
+		{"jsonb[]", []interface{}{map[string]interface{}{"a": "b"}}, "{\"{\\\"a\\\":\\\"b\\\"}\n\"}"},
+		{"citext[]", []interface{}{true, json.Number("1.50")}, `{"true","1.50"}`},
+		{"inet[]", []interface{}{}, "{}"},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, tt.v)
+		if err != nil {
+			t.Errorf("coerce(%s, %v): %v", tt.typ, tt.v, err)
+			continue
+		}
+		// the literal is sent as text by every protocol
+		if got, ok := v.(string); !ok || got != tt.want {
+			t.Errorf("coerce(%s, %v) = %#v, want %s", tt.typ, tt.v, v, tt.want)
+		}
+	}
+}
+
+func TestCoerceEmptyArray(t *testing.T) {
+	for _, typ := range []string{"int4[]", "numeric[]", "uuid[]", "date[]", "text[]", "varchar[]"} {
+		v, err := coerce(typ, []interface{}{})
+		if err != nil {
+			t.Errorf("coerce(%s, []): %v", typ, err)
+			continue
+		}
+		if got := text(t, v); got != "{}" {
+			t.Errorf("coerce(%s, []) = %s, want {}", typ, got)
+		}
+		if dv, err := v.(driver.Valuer).Value(); err != nil || dv != "{}" {
+			t.Errorf("coerce(%s, []).Value() = %#v, %v, want {}", typ, dv, err)
+		}
+	}
+}
+
+func TestCoerceEpochDate(t *testing.T) {
+	defer func(loc *time.Location) { timeZone = loc }(timeZone)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		loc  *time.Location
+		n    string
+		want string
+	}{
+		{nil, "3600", "1970-01-01"},
+		{nil, "-1", "1969-12-31"},
+		{newYork, "3600", "1969-12-31"},
+		{newYork, "86400", "1970-01-01"},
+		{time.UTC, "3600", "1970-01-01"},
+	}
+	for _, tt := range tests {
+		timeZone = tt.loc
+		v, err := coerce("date", json.Number(tt.n))
+		if err != nil {
+			t.Errorf("coerce(date, %s) in %v: %v", tt.n, tt.loc, err)
+			continue
+		}
+		d := &pgtype.Date{}
+		if err := d.Set(v); err != nil {
+			t.Fatal(err)
+		}
+		if got := text(t, d); got != tt.want {
+			t.Errorf("coerce(date, %s) in %v = %s, want %s", tt.n, tt.loc, got, tt.want)
+		}
+		if got := text(t, v); got != tt.want+"T00:00:00Z" {
+			t.Errorf("coerce(date, %s) in %v copied as %s, want %sT00:00:00Z", tt.n, tt.loc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/pkg/errors"
+)
+
+// compositeField is a field of a composite type, typed like the columns
+// of tableColumns.
+type compositeField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// compositeFields returns the fields of the composite type columns of a
+// table in their declared order.
+func compositeFields(pg *pgx.Conn, dbName, schema, tableName string) (map[string][]compositeField, error) {
+	rows, err := pg.Query(
+		`SELECT c.column_name, a.attribute_name,
+			CASE a.data_type
+				WHEN 'ARRAY' THEN substr(a.attribute_udt_name, 2) || '[]'
+				WHEN 'USER-DEFINED' THEN a.attribute_udt_name
+				ELSE a.data_type END
+		FROM information_schema.columns c
+			JOIN information_schema.attributes a
+				ON a.udt_catalog = c.udt_catalog AND a.udt_schema = c.udt_schema
+				AND a.udt_name = c.udt_name
+		WHERE c.table_name = $1 AND c.table_catalog = $2
+			AND c.table_schema = $3 AND c.data_type = 'USER-DEFINED'
+		ORDER BY c.column_name, a.ordinal_position`,
+		tableName, dbName, schema,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "composite type query failed")
+	}
+	defer rows.Close()
+	composites := make(map[string][]compositeField)
+	for rows.Next() {
+		var col string
+		var f compositeField
+		if err := rows.Scan(&col, &f.Name, &f.Type); err != nil {
+			return nil, errors.Wrap(err, "scan failed")
+		}
+		composites[col] = append(composites[col], f)
+	}
+	return composites, rows.Err()
+}
+
+// compositeEscaper escapes the characters with a meaning inside a quoted
+// field of a row literal.
+var compositeEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// toComposite formats an object as a row literal, e.g. ("1 Main St",NYC),
+// with the values of its keys in the order of the fields. Missing keys
+// and nulls are NULL, keys without a field are an error. Values of
+// nested composite fields are left as their JSON text.
+func toComposite(fields []compositeField, obj map[string]interface{}) (string, error) {
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f.Name] = true
+	}
+	var unknown []string
+	for k := range obj {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", errors.Errorf("no field %s in the composite type", strings.Join(unknown, ", "))
+	}
+	ci := pgtype.NewConnInfo()
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v, err := coerce(f.Type, obj[f.Name])
+		if err != nil {
+			return "", errors.Wrapf(err, "field %s", f.Name)
+		}
+		s, null, err := textValue(ci, v)
+		if err != nil {
+			return "", errors.Wrapf(err, "field %s", f.Name)
+		}
+		// an empty field is NULL, a quoted one is a value, even ""
+		if !null {
+			b.WriteByte('"')
+			compositeEscaper.WriteString(&b, s)
+			b.WriteByte('"')
+		}
+	}
+	b.WriteByte(')')
+	return b.String(), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToComposite(t *testing.T) {
+	fields := []compositeField{
+		{"street", "text"},
+		{"zip", "integer"},
+		{"since", "date"},
+		{"geo", "jsonb"},
+	}
+	tests := []struct {
+		obj  map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"street": "1 Main St", "zip": json.Number("10001")}, `("1 Main St","10001",,)`},
+		{map[string]interface{}{"street": "", "zip": nil}, `("",,,)`},
+		{map[string]interface{}{"street": `a "b" \c`}, `("a \"b\" \\c",,,)`},
+		{map[string]interface{}{"since": "2019-01-02"}, `(,,"2019-01-02T00:00:00Z",)`},
+		{map[string]interface{}{"geo": map[string]interface{}{"lat": json.Number("1")}}, `(,,,"{\"lat\":1}` + "\n" + `")`},
+		{map[string]interface{}{}, `(,,,)`},
+	}
+	for _, tt := range tests {
+		got, err := toComposite(fields, tt.obj)
+		if err != nil {
+			t.Errorf("toComposite(%v): %v", tt.obj, err)
+		} else if got != tt.want {
+			t.Errorf("toComposite(%v) = %s, want %s", tt.obj, got, tt.want)
+		}
+	}
+}
+
+func TestToCompositeInvalid(t *testing.T) {
+	fields := []compositeField{{"street", "text"}, {"zip", "integer"}}
+	for _, obj := range []map[string]interface{}{
+		{"street": "x", "city": "y"},
+		{"zip": "abc"},
+	} {
+		if got, err := toComposite(fields, obj); err == nil {
+			t.Errorf("toComposite(%v) = %s, want an error", obj, got)
+		}
+	}
+}
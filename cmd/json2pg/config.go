@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// loadConfig sets the flags not given on the command line from a JSON
+// object keyed by flag name, e.g. {"d": "shop", "map": ["id:user_id"]}.
+// Repeatable flags take an array of values.
+func loadConfig(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	var values map[string]interface{}
+	if err := dec.Decode(&values); err != nil {
+		return errors.Wrap(err, "invalid config file")
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if flag.Lookup(k) == nil || k == "config" {
+			return errors.Errorf("unknown setting %q in config file", k)
+		}
+		if set[k] {
+			continue
+		}
+		items, ok := values[k].([]interface{})
+		if !ok {
+			items = []interface{}{values[k]}
+		}
+		for _, item := range items {
+			switch item.(type) {
+			case string, json.Number, bool:
+			default:
+				return errors.Errorf("invalid value for %s in config file: %v", k, item)
+			}
+			if err := flag.Set(k, fmt.Sprint(item)); err != nil {
+				return errors.Wrapf(err, "invalid value for %s in config file", k)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// connConfig builds the connection settings from the command line.
+// Settings given with -dsn take precedence over the individual flags.
+func connConfig() (pgx.ConnConfig, error) {
+	if err := envDefaults(); err != nil {
+		return pgx.ConnConfig{}, err
+	}
+	params, err := parsePairs(*runtimeParams, "=")
+	if err != nil {
+		return pgx.ConnConfig{}, errors.Wrap(err, "invalid -param")
+	}
+	if _, ok := params["application_name"]; !ok {
+		params["application_name"] = *appName
+	}
+	if _, ok := params["statement_timeout"]; !ok && *statementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(int64(*statementTimeout/time.Millisecond), 10)
+	}
+	config := pgx.ConnConfig{
+		Host:          *pgHost,
+		User:          *pgUser,
+		Password:      *pgPassword,
+		Port:          uint16(*pgPort),
+		Database:      *databaseName,
+		RuntimeParams: params,
+	}
+	if *dsn != "" {
+		c, err := pgx.ParseConnectionString(*dsn)
+		if err != nil {
+			return config, errors.Wrap(err, "invalid dsn")
+		}
+		config = config.Merge(c)
+		// the dsn carries its own sslmode, which may disable TLS
+		config.TLSConfig = c.TLSConfig
+		config.UseFallbackTLS = c.UseFallbackTLS
+		config.FallbackTLSConfig = c.FallbackTLSConfig
+	} else if err := configTLS(&config); err != nil {
+		return config, err
+	}
+	if strings.HasPrefix(config.Host, "/") {
+		// pgx only dials a unix socket if the path exists, otherwise it
+		// would try the path as a TCP host name
+		if _, err := os.Stat(config.Host); err != nil {
+			return config, errors.Wrap(err, "invalid socket directory")
+		}
+		// like libpq, never use SSL over a unix socket
+		config.TLSConfig = nil
+		config.UseFallbackTLS = false
+		config.FallbackTLSConfig = nil
+	}
+	if *connectTimeout > 0 {
+		d := &net.Dialer{Timeout: *connectTimeout, KeepAlive: 5 * time.Minute}
+		config.Dial = d.Dial
+	}
+	// the extended protocol prepares every statement in a round trip of
+	// its own, which breaks behind pgbouncer in transaction mode
+	config.PreferSimpleProtocol = *simpleProtocol
+	return config, nil
+}
+
+// maxRetryDelay caps the exponential backoff between connection attempts.
+const maxRetryDelay = 30 * time.Second
+
+// connect connects to the database, retrying up to -connect-retries
+// times with exponential backoff while the server is unreachable or not
+// ready to accept connections.
+func connect(config pgx.ConnConfig) (*pgx.Conn, error) {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		debugf("Connecting to %s as %s (database %s)", address(config), config.User, config.Database)
+		pg, err := pgx.Connect(config)
+		if err == nil {
+			if err := setRole(pg); err != nil {
+				pg.Close()
+				return nil, err
+			}
+			return pg, nil
+		}
+		if attempt >= *connectRetries || !retryable(err) {
+			return nil, err
+		}
+		infof("Failed to connect to db, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// retryable reports whether connecting may succeed later after err.
+// Besides network errors, the server reports errors of class 08 and
+// 57P03 cannot_connect_now while it starts up or shuts down, and 53300
+// too_many_connections until a connection is closed. Other errors of
+// the server, like 28P01 invalid_password or 3D000 for a missing
+// database, will not go away by retrying.
+func retryable(err error) bool {
+	e, ok := err.(pgx.PgError)
+	if !ok {
+		return true
+	}
+	return strings.HasPrefix(e.Code, "08") || e.Code == "57P03" || e.Code == "53300"
+}
+
+// setRole switches the session of pg to -role, so rows are inserted
+// with its privileges and row level security policies.
+func setRole(pg *pgx.Conn) error {
+	if *role == "" {
+		return nil
+	}
+	if _, err := pg.Exec("SET ROLE " + sqlDialect.quote(*role)); err != nil {
+		return errors.Wrapf(err, "unable to set role %s", *role)
+	}
+	debugf("Running as role %s", *role)
+	return nil
+}
+
+// address describes the server to connect to, without credentials.
+func address(config pgx.ConnConfig) string {
+	if strings.HasPrefix(config.Host, "/") {
+		return fmt.Sprintf("%s/.s.PGSQL.%d", config.Host, config.Port)
+	}
+	return fmt.Sprintf("%s:%d", config.Host, config.Port)
+}
+
+// envDefaults replaces connection flags left at their defaults with the
+// standard libpq environment variables, the same way psql does.
+func envDefaults() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, e := range []struct {
+		flag, env string
+		v         *string
+	}{
+		{"U", "PGUSER", pgUser},
+		{"P", "PGPASSWORD", pgPassword},
+		{"h", "PGHOST", pgHost},
+		{"d", "PGDATABASE", databaseName},
+	} {
+		if v := os.Getenv(e.env); v != "" && !set[e.flag] {
+			*e.v = v
+		}
+	}
+	if e := os.Getenv("PGPORT"); e != "" && !set["p"] {
+		port, err := strconv.ParseUint(e, 10, 16)
+		if err != nil {
+			return errors.Wrap(err, "invalid PGPORT")
+		}
+		*pgPort = uint(port)
+	}
+	return nil
+}
+
+// configTLS applies -sslmode and -sslrootcert to config following the
+// libpq semantics of each mode.
+func configTLS(config *pgx.ConnConfig) error {
+	switch *sslMode {
+	case "disable":
+		return nil
+	case "allow":
+		config.UseFallbackTLS = true
+		config.FallbackTLSConfig = &tls.Config{InsecureSkipVerify: true}
+		return nil
+	case "prefer":
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		config.UseFallbackTLS = true
+	case "require":
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	case "verify-ca", "verify-full":
+		config.TLSConfig = &tls.Config{ServerName: config.Host}
+	default:
+		return errors.Errorf("invalid sslmode %q", *sslMode)
+	}
+	if *sslRootCert != "" {
+		pem, err := ioutil.ReadFile(*sslRootCert)
+		if err != nil {
+			return errors.Wrap(err, "unable to read CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificates found in %s", *sslRootCert)
+		}
+		config.TLSConfig.RootCAs = pool
+	}
+	if *sslMode == "verify-ca" {
+		// verify the chain but not the host name
+		roots := config.TLSConfig.RootCAs
+		config.TLSConfig.InsecureSkipVerify = true
+		config.TLSConfig.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, len(raw))
+			for i, b := range raw {
+				c, err := x509.ParseCertificate(b)
+				if err != nil {
+					return err
+				}
+				certs[i] = c
+			}
+			if len(certs) == 0 {
+				return errors.New("server did not send a certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, c := range certs[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{errors.New("EOF"), true},
+		{pgx.PgError{Code: "57P03", Message: "the database system is starting up"}, true},
+		{pgx.PgError{Code: "08006", Message: "connection failure"}, true},
+		{pgx.PgError{Code: "53300", Message: "sorry, too many clients already"}, true},
+		{pgx.PgError{Code: "28P01", Message: "password authentication failed"}, false},
+		{pgx.PgError{Code: "28000", Message: "no pg_hba.conf entry"}, false},
+		{pgx.PgError{Code: "3D000", Message: "database does not exist"}, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
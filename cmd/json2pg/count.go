@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// inputStats describes the input as printed by -count-only.
+type inputStats struct {
+	Rows      int      `json:"rows"`
+	KeySets   int      `json:"key_sets"`
+	Matched   []string `json:"matched_keys"`
+	Unmatched []string `json:"unmatched_keys"`
+}
+
+// countRows decodes the whole input and reports the number of rows, the
+// distinct sets of keys and which keys have a matching column, without
+// inserting anything.
+func countRows(l *loader, input *rowReader) error {
+	stats := inputStats{Matched: []string{}, Unmatched: []string{}}
+	sets := make(map[string]bool)
+	keys := make(map[string]bool)
+	for {
+		row, err := input.Next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*oversizedRow); ok {
+			stats.Rows++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to decode row #%d: %v", *skip+stats.Rows, err)
+		}
+		stats.Rows++
+		l.reshape(row)
+		rowKeys := make([]string, 0, len(row))
+		for k := range row {
+			rowKeys = append(rowKeys, k)
+			keys[k] = true
+		}
+		sort.Strings(rowKeys)
+		sets[strings.Join(rowKeys, "\x00")] = true
+	}
+	stats.KeySets = len(sets)
+	for k := range keys {
+		if _, ok := l.column(k); ok {
+			stats.Matched = append(stats.Matched, k)
+		} else {
+			stats.Unmatched = append(stats.Unmatched, k)
+		}
+	}
+	sort.Strings(stats.Matched)
+	sort.Strings(stats.Unmatched)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	fmt.Printf("Rows: %d\n", stats.Rows)
+	fmt.Printf("Distinct key sets: %d\n", stats.KeySets)
+	fmt.Printf("Keys matching a column of %s: %s\n", *tableName, strings.Join(stats.Matched, ", "))
+	fmt.Printf("Keys without a column: %s\n", strings.Join(stats.Unmatched, ", "))
+	return nil
+}
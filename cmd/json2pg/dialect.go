@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// dialect describes how statements are written for a database speaking
+// the postgres protocol.
+type dialect struct {
+	// quoteChar encloses identifiers, doubled inside of them
+	quoteChar string
+	// param precedes the number of a bind parameter
+	param string
+	// vacuum is false for databases without VACUUM, where -vacuum only
+	// runs ANALYZE
+	vacuum bool
+}
+
+// dialects holds the -dialect names. CockroachDB and YugabyteDB quote
+// identifiers and number placeholders like postgres. All statements
+// quote their names and number their placeholders through the -dialect.
+var dialects = map[string]*dialect{
+	"postgres":    {quoteChar: `"`, param: "$", vacuum: true},
+	"yugabytedb":  {quoteChar: `"`, param: "$", vacuum: true},
+	"cockroachdb": {quoteChar: `"`, param: "$", vacuum: false},
+}
+
+// sqlDialect is the -dialect in use.
+var sqlDialect = dialects["postgres"]
+
+// dialectNames returns the -dialect names in alphabetical order.
+func dialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for n := range dialects {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// quote quotes a name, doubling quotes inside of it.
+func (d *dialect) quote(name string) string {
+	return d.quoteChar + strings.Replace(name, d.quoteChar, d.quoteChar+d.quoteChar, -1) + d.quoteChar
+}
+
+// table quotes a qualified table name.
+func (d *dialect) table(id pgx.Identifier) string {
+	parts := make([]string, len(id))
+	for i, p := range id {
+		parts[i] = d.quote(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// placeholder returns the bind parameter for the n-th value, from 1.
+func (d *dialect) placeholder(n int) string {
+	return d.param + strconv.Itoa(n)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx"
+)
+
+func TestDialectQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"id", `"id"`},
+		{"Order Total", `"Order Total"`},
+		{`a"b`, `"a""b"`},
+		{"", `""`},
+	}
+	for _, n := range dialectNames() {
+		d := dialects[n]
+		for _, tt := range tests {
+			if got := d.quote(tt.name); got != tt.want {
+				t.Errorf("%s: quote(%q) = %s, want %s", n, tt.name, got, tt.want)
+			}
+			// quoted like pgx quotes them
+			if got := d.table(pgx.Identifier{"public", tt.name}); got != (pgx.Identifier{"public", tt.name}).Sanitize() {
+				t.Errorf("%s: table(public, %q) = %s, want %s", n, tt.name, got, pgx.Identifier{"public", tt.name}.Sanitize())
+			}
+		}
+		if got := d.placeholder(12); got != "$12" {
+			t.Errorf("%s: placeholder(12) = %s, want $12", n, got)
+		}
+	}
+}
+
+func TestDialectQuoteChar(t *testing.T) {
+	d := &dialect{quoteChar: "`", param: "?"}
+	if got := d.table(pgx.Identifier{"s", "a`b"}); got != "`s`.`a``b`" {
+		t.Errorf("table(s, a`b) = %s", got)
+	}
+	if got := d.placeholder(1); got != "?1" {
+		t.Errorf("placeholder(1) = %s", got)
+	}
+}
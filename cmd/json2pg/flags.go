@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// listFlag is a flag that may be repeated, each value holding a comma
+// separated list.
+type listFlag []string
+
+// listVar defines a listFlag with the given name and usage.
+func listVar(name, usage string) *listFlag {
+	f := new(listFlag)
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	*f = append(*f, splitList(v)...)
+	return nil
+}
+
+// repeatFlag is a flag that may be repeated, keeping every value whole
+// for values that may contain commas.
+type repeatFlag []string
+
+// repeatVar defines a repeatFlag with the given name and usage.
+func repeatVar(name, usage string) *repeatFlag {
+	f := new(repeatFlag)
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *repeatFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *repeatFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// parsePairs parses "key<sep>value" items.
+func parsePairs(items []string, sep string) (map[string]string, error) {
+	pairs := make(map[string]string, len(items))
+	for _, item := range items {
+		i := strings.Index(item, sep)
+		if i <= 0 || i == len(item)-len(sep) {
+			return nil, errors.Errorf("expected key%svalue, got %q", sep, item)
+		}
+		pairs[item[:i]] = item[i+len(sep):]
+	}
+	return pairs, nil
+}
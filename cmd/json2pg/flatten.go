@@ -0,0 +1,97 @@
+package main
+
+import "strings"
+
+// reshape prepares a decoded row for the column lookup: the dotted
+// -map paths are extracted from nested objects and, with -flatten,
+// nested objects without a column of their own are replaced by their
+// keys prefixed with the path to them.
+func (l *loader) reshape(row map[string]interface{}) {
+	for k := range l.rename {
+		if _, ok := row[k]; ok || !strings.Contains(k, ".") {
+			continue
+		}
+		row[k] = lookupPath(row, strings.Split(k, "."))
+	}
+	if *flatten {
+		l.flattenRow(row)
+	}
+}
+
+// lookupPath returns the value at path in nested objects, or nil if a
+// key along the path is missing.
+func lookupPath(v interface{}, path []string) interface{} {
+	for _, k := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[k]
+	}
+	return v
+}
+
+// flattenRow replaces the nested objects of row without a column of
+// their own by their keys, named by the path joined with "_", e.g.
+// user_name for {"user": {"name": ...}}.
+func (l *loader) flattenRow(row map[string]interface{}) {
+	keys := make([]string, 0, len(row))
+	for k := range row {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		obj, ok := row[k].(map[string]interface{})
+		if !ok || l.keepObject(k) {
+			continue
+		}
+		if l.objectColumn(k) {
+			continue
+		}
+		delete(row, k)
+		l.flattenInto(row, k+"_", obj)
+	}
+}
+
+func (l *loader) flattenInto(row map[string]interface{}, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		name := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok {
+			if !l.objectColumn(name) {
+				l.flattenInto(row, name+"_", nested)
+				continue
+			}
+		}
+		row[name] = v
+	}
+}
+
+// keepObject reports whether the top level key k holds an object used
+// as a whole, by -nested.
+func (l *loader) keepObject(k string) bool {
+	for _, n := range l.nested {
+		if n.key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// objectColumn reports whether the key k has a column an object is
+// inserted into as a whole. With -no-auto-json that is only a column of
+// a type made from objects, like jsonb or hstore, while others are
+// flattened.
+func (l *loader) objectColumn(k string) bool {
+	col, ok := l.column(k)
+	if !ok {
+		return false
+	}
+	if !*noAutoJSON {
+		return true
+	}
+	typ := l.cols[col]
+	if h, ok := l.hints[col]; ok {
+		typ = h
+	}
+	_, composite := l.composites[col]
+	return objectTypes[typ] || rangeTypes[typ].value != nil || composite
+}
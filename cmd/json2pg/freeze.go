@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/pkg/errors"
+)
+
+// copyEscaper escapes the characters with a meaning in the COPY text
+// format.
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// copyText encodes rows in the COPY text format of -copy.
+func copyText(rows [][]interface{}) (*bytes.Buffer, error) {
+	ci := pgtype.NewConnInfo()
+	var buf bytes.Buffer
+	for _, vals := range rows {
+		for i, v := range vals {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			s, null, err := textValue(ci, v)
+			if err != nil {
+				return nil, err
+			}
+			if null {
+				buf.WriteString(`\N`)
+			} else {
+				copyEscaper.WriteString(&buf, s)
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return &buf, nil
+}
+
+// textValue returns the text representation of a value returned by
+// coerce, or null.
+func textValue(ci *pgtype.ConnInfo, v interface{}) (string, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", true, nil
+	case pgtype.TextEncoder:
+		b, err := v.EncodeText(ci, nil)
+		return string(b), b == nil, err
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			return "", false, err
+		}
+		return textValue(ci, dv)
+	case string:
+		return v, false, nil
+	case json.Number:
+		return v.String(), false, nil
+	case int64:
+		return strconv.FormatInt(v, 10), false, nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), false, nil
+	case bool:
+		if v {
+			return "t", false, nil
+		}
+		return "f", false, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), false, nil
+	case []byte:
+		return `\x` + hex.EncodeToString(v), false, nil
+	}
+	return "", false, errors.Errorf("can not copy %T", v)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+func TestTextValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+		null bool
+	}{
+		{nil, "", true},
+		{"a", "a", false},
+		{json.Number("1.50"), "1.50", false},
+		{int64(-3), "-3", false},
+		{1.5, "1.5", false},
+		{true, "t", false},
+		{false, "f", false},
+		{time.Date(2019, 1, 2, 3, 4, 5, 6000, time.UTC), "2019-01-02T03:04:05.000006Z", false},
+		{[]byte{0, 0xff}, `\x00ff`, false},
+		{geoJSON(`{"type":"Point"}`), `{"type":"Point"}`, false},
+		{&pgtype.Int4{Int: 7, Status: pgtype.Present}, "7", false},
+		{&pgtype.Int4{Status: pgtype.Null}, "", true},
+	}
+	ci := pgtype.NewConnInfo()
+	for _, tt := range tests {
+		got, null, err := textValue(ci, tt.v)
+		if err != nil {
+			t.Errorf("textValue(%#v): %v", tt.v, err)
+		} else if got != tt.want || null != tt.null {
+			t.Errorf("textValue(%#v) = %q, %v, want %q, %v", tt.v, got, null, tt.want, tt.null)
+		}
+	}
+	if _, _, err := textValue(ci, struct{}{}); err == nil {
+		t.Error("textValue(struct{}{}): want an error")
+	}
+}
+
+func TestCopyText(t *testing.T) {
+	rows := [][]interface{}{
+		{int64(1), "plain", nil},
+		{int64(2), "tab\there\nnew line\r", `back\slash`},
+		{int64(3), "", `\N`},
+	}
+	buf, err := copyText(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1\tplain\t\\N\n" +
+		"2\ttab\\there\\nnew line\\r\tback\\\\slash\n" +
+		"3\t\t\\\\N\n"
+	if got := buf.String(); got != want {
+		t.Errorf("copyText = %q, want %q", got, want)
+	}
+	if _, err := copyText([][]interface{}{{struct{}{}}}); err == nil {
+		t.Error("copyText of an unknown type: want an error")
+	}
+}
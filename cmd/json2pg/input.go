@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// inputNames returns the input files given with -f and as arguments
+// after the flags. Stdin is used if there are none.
+func inputNames() []string {
+	names := append(splitList(*fileName), flag.Args()...)
+	if len(names) == 0 {
+		return []string{""}
+	}
+	return names
+}
+
+// errNoRows is returned by openRows for an input without rows.
+var errNoRows = errors.New("No rows in the input file")
+
+// firstInput returns the name of the first input, the archive with
+// -zip.
+func firstInput() string {
+	if *zipFile != "" {
+		return *zipFile
+	}
+	return inputNames()[0]
+}
+
+// openRows opens the inputs and skips the first -skip rows, checking
+// that at least one row follows. The returned function closes the
+// inputs.
+func openRows() (*rowReader, func(), error) {
+	if *rootKey != "" && *ndjson {
+		return nil, nil, usageError("-root-path can not be combined with -ndjson")
+	}
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	var inputs []io.Reader
+	var names []string
+	if *zipFile != "" {
+		if *fileName != "" || flag.NArg() > 0 {
+			return nil, nil, usageError("-zip can not be combined with other input files")
+		}
+		archive, entries, entryNames, err := openZip(*zipFile, *zipEntry, *gzipInput)
+		if err != nil {
+			return nil, nil, withCode(exitConfig, "Failed to open zip archive: %v", err)
+		}
+		for i, e := range entries {
+			closers = append(closers, e)
+			inputs = append(inputs, e)
+			names = append(names, *zipFile+"/"+entryNames[i])
+		}
+		// the archive is closed after its entries
+		closers = append(closers, archive)
+	} else {
+		for _, name := range inputNames() {
+			file, err := openInput(name, *gzipInput)
+			if err != nil {
+				closeAll()
+				return nil, nil, withCode(exitConfig, "Failed to open input file for reading: %v", err)
+			}
+			closers = append(closers, file)
+			inputs = append(inputs, file)
+			if name == "" {
+				name = "-"
+			}
+			names = append(names, name)
+		}
+	}
+	var rootPath []string
+	if *rootKey != "" {
+		rootPath = strings.Split(*rootKey, ".")
+	}
+	input, err := newRowReader(inputs, names, *ndjson, rootPath)
+	if err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("Failed to decode input data: %v", err)
+	}
+	if err := input.Skip(*skip); err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("Failed to skip input rows: %v", err)
+	}
+	input.limit = *limit
+	input.maxBytes = *maxRowBytes
+	if *sortBy != "" {
+		if err := input.SortBy(strings.Split(*sortBy, ".")); err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("Failed to decode input data: %v", err)
+		}
+	}
+	if _, err := input.Sample(1); err != nil {
+		closeAll()
+		return nil, nil, fmt.Errorf("Failed to decode input data: %v", err)
+	} else if len(input.sampled) == 0 {
+		closeAll()
+		return nil, nil, errNoRows
+	}
+	return input, closeAll, nil
+}
+
+// openInput opens the named file, http(s) URL, or stdin if name is
+// empty or "-". The input is decompressed if gz is set or the name, or
+// the path of the URL, ends with ".gz".
+func openInput(name string, gz bool) (io.ReadCloser, error) {
+	var f io.ReadCloser
+	path := name
+	if name == "" || name == "-" {
+		f = ioutil.NopCloser(os.Stdin)
+	} else if u, ok := inputURL(name); ok {
+		var err error
+		if f, err = fetch(u); err != nil {
+			return nil, err
+		}
+		path = u.Path
+	} else {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return nil, err
+		}
+	}
+	return decompress(f, path, gz)
+}
+
+// decompress wraps f, named name, in a gzip decompressor if gz is set or
+// the name ends with ".gz".
+func decompress(f io.ReadCloser, name string, gz bool) (io.ReadCloser, error) {
+	if !gz && !strings.HasSuffix(name, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "gzip")
+	}
+	return gzipReadCloser{zr, f}, nil
+}
+
+// inputURL parses name if it is an http or https URL.
+func inputURL(name string) (*url.URL, bool) {
+	u, err := url.Parse(name)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false
+	}
+	return u, true
+}
+
+// fetch requests u and returns the response body, which is streamed
+// while rows are decoded. -timeout limits connecting and waiting for
+// the response headers, not reading the body.
+func fetch(u *url.URL) (io.ReadCloser, error) {
+	d := &net.Dialer{Timeout: *httpTimeout, KeepAlive: 30 * time.Second}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
+		TLSHandshakeTimeout:   *httpTimeout,
+		ResponseHeaderTimeout: *httpTimeout,
+	}}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		// do not print credentials given in the URL
+		shown := *u
+		shown.User = nil
+		return nil, errors.Errorf("GET %s: %s", shown.String(), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// gzipReadCloser closes both the decompressor and the underlying file.
+type gzipReadCloser struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// rowReader decodes a JSON array of objects, or a stream of newline
+// delimited objects, one element at a time, so the input never has to
+// fit into memory as a whole. Several inputs are read one after another
+// as a single sequence of rows.
+type rowReader struct {
+	dec    *json.Decoder
+	array  bool
+	ndjson bool
+	// rootPath holds the keys leading to the array of rows
+	rootPath []string
+	// done is set once the last input is read
+	done bool
+	// inputs holds the inputs not started yet, names their names
+	inputs []io.Reader
+	names  []string
+	// current is the name of the input being decoded, name that of the
+	// input of the row last returned by Next
+	current string
+	name    string
+	// bytes counts the bytes read from the inputs
+	bytes int64
+	// limit is the number of rows returned by Next, 0 for all rows
+	limit int
+	read  int
+	// sampled holds rows read ahead by Sample
+	sampled []sampledRow
+	// maxBytes limits the encoded size of a row, 0 for no limit
+	maxBytes int
+}
+
+// sampledRow is a row read ahead, along with the name of its input and
+// the error returned for it by Next.
+type sampledRow struct {
+	row  map[string]interface{}
+	name string
+	err  error
+}
+
+// oversizedRow is returned by Next for a row larger than -max-row-bytes.
+// Reading may go on with the next row.
+type oversizedRow struct {
+	size, max int
+}
+
+func (e *oversizedRow) Error() string {
+	return fmt.Sprintf("row of %d bytes exceeds -max-row-bytes %d", e.size, e.max)
+}
+
+// newRowReader starts reading the first input, consuming the opening
+// bracket of the array unless the input is newline delimited. The array
+// is looked up under rootPath in an object wrapping it, if given.
+func newRowReader(inputs []io.Reader, names []string, ndjson bool, rootPath []string) (*rowReader, error) {
+	r := &rowReader{ndjson: ndjson, rootPath: rootPath, inputs: inputs, names: names}
+	if err := r.start(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// start switches to the next input.
+func (r *rowReader) start() error {
+	r.dec = json.NewDecoder(countingReader{r.inputs[0], &r.bytes})
+	r.inputs = r.inputs[1:]
+	if len(r.names) > 0 {
+		r.current, r.names = r.names[0], r.names[1:]
+	}
+	// keep numbers as decoded text so big integers and decimals do not
+	// lose precision going through float64
+	r.dec.UseNumber()
+	r.array = false
+	if r.ndjson {
+		return nil
+	}
+	for i, key := range r.rootPath {
+		if err := r.expect('{', "object"); err != nil {
+			return err
+		}
+		if err := r.seek(key); err != nil {
+			return errors.Wrapf(err, "-root-path %s", strings.Join(r.rootPath[:i+1], "."))
+		}
+	}
+	if err := r.expect('[', "array of objects"); err != nil {
+		return err
+	}
+	r.array = true
+	return nil
+}
+
+// expect consumes the next token, which must be the delimiter d opening
+// a value described by what.
+func (r *rowReader) expect(d json.Delim, what string) error {
+	t, err := r.dec.Token()
+	if err != nil {
+		return err
+	}
+	if got, ok := t.(json.Delim); !ok || got != d {
+		return errors.Errorf("expected %s, got %v", what, t)
+	}
+	return nil
+}
+
+// seek skips the keys of the object being decoded up to key, leaving its
+// value next.
+func (r *rowReader) seek(key string) error {
+	for r.dec.More() {
+		t, err := r.dec.Token()
+		if err != nil {
+			return err
+		}
+		if t == key {
+			return nil
+		}
+		var skipped json.RawMessage
+		if err := r.dec.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+	return errors.New("key not found")
+}
+
+// countingReader adds the number of bytes read to n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// Next returns the next row or io.EOF after the last one.
+func (r *rowReader) Next() (map[string]interface{}, error) {
+	if r.limit > 0 && r.read >= r.limit {
+		return nil, io.EOF
+	}
+	r.read++
+	if len(r.sampled) > 0 {
+		s := r.sampled[0]
+		r.sampled = r.sampled[1:]
+		r.name = s.name
+		return s.row, s.err
+	}
+	row, err := r.next()
+	r.name = r.current
+	return row, err
+}
+
+// Skip decodes and discards the next n rows.
+func (r *rowReader) Skip(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := r.next(); err == io.EOF {
+			return nil
+		} else if _, ok := err.(*oversizedRow); err != nil && !ok {
+			return errors.Wrapf(err, "row #%d", i)
+		}
+	}
+	return nil
+}
+
+// Sample reads up to n rows ahead. The rows are still returned by Next.
+// Oversized rows count towards n but are left out.
+func (r *rowReader) Sample(n int) ([]map[string]interface{}, error) {
+	if r.limit > 0 && n > r.limit {
+		n = r.limit
+	}
+	for len(r.sampled) < n {
+		row, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*oversizedRow); err != nil && !ok {
+			return nil, errors.Wrapf(err, "row #%d", len(r.sampled))
+		}
+		r.sampled = append(r.sampled, sampledRow{row, r.current, err})
+	}
+	rows := make([]map[string]interface{}, 0, len(r.sampled))
+	for _, s := range r.sampled {
+		if s.err == nil {
+			rows = append(rows, s.row)
+		}
+	}
+	return rows, nil
+}
+
+// SortBy reads all remaining rows ahead and orders them by the value
+// found at path, so Next returns them sorted. Numbers come before
+// strings, which are compared byte by byte, then booleans; rows without
+// the value and oversized rows come last.
+func (r *rowReader) SortBy(path []string) error {
+	n := -1
+	if r.limit > 0 {
+		n = r.limit
+	}
+	for n < 0 || len(r.sampled) < n {
+		row, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*oversizedRow); err != nil && !ok {
+			return errors.Wrapf(err, "row #%d", len(r.sampled))
+		}
+		r.sampled = append(r.sampled, sampledRow{row, r.current, err})
+	}
+	keys := make([]interface{}, len(r.sampled))
+	for i, s := range r.sampled {
+		keys[i] = lookupPath(s.row, path)
+	}
+	idx := make([]int, len(r.sampled))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return sortLess(keys[idx[i]], keys[idx[j]])
+	})
+	sorted := make([]sampledRow, len(idx))
+	for i, k := range idx {
+		sorted[i] = r.sampled[k]
+	}
+	r.sampled = sorted
+	return nil
+}
+
+// sortLess orders the values of -sort-by.
+func sortLess(a, b interface{}) bool {
+	ra, rb := sortRank(a), sortRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	switch a := a.(type) {
+	case json.Number:
+		fa, errA := a.Float64()
+		fb, errB := b.(json.Number).Float64()
+		if errA == nil && errB == nil && fa != fb {
+			return fa < fb
+		}
+		return a < b.(json.Number)
+	case string:
+		return a < b.(string)
+	case bool:
+		return !a && b.(bool)
+	}
+	return false
+}
+
+// sortRank groups values of different JSON types for sortLess.
+func sortRank(v interface{}) int {
+	switch v.(type) {
+	case json.Number:
+		return 0
+	case string:
+		return 1
+	case bool:
+		return 2
+	case nil:
+		return 4
+	}
+	return 3
+}
+
+func (r *rowReader) next() (map[string]interface{}, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	for !r.dec.More() {
+		if r.array {
+			if _, err := r.dec.Token(); err != nil {
+				return nil, err
+			}
+		}
+		if len(r.inputs) == 0 {
+			// keys of a -root-path object following the array would be
+			// taken for more rows
+			r.done = true
+			return nil, io.EOF
+		}
+		if err := r.start(); err != nil {
+			return nil, err
+		}
+	}
+	var row map[string]interface{}
+	if r.maxBytes <= 0 {
+		if err := r.dec.Decode(&row); err != nil {
+			return nil, err
+		}
+		return row, nil
+	}
+	// the raw element is checked before it is decoded into a map,
+	// which takes several times its size
+	var raw json.RawMessage
+	if err := r.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if len(raw) > r.maxBytes {
+		return nil, &oversizedRow{size: len(raw), max: r.maxBytes}
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// ReadAll reads all remaining rows.
+func (r *rowReader) ReadAll() ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "row #%d", len(rows))
+		}
+		rows = append(rows, row)
+	}
+}
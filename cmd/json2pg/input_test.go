@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// nextRows reads the rows left in r.
+func nextRows(t *testing.T, r *rowReader) []map[string]interface{} {
+	t.Helper()
+	var rows []map[string]interface{}
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("Next after the last row: got %v, want io.EOF", err)
+	}
+	return rows
+}
+
+// readRows reads all rows of r, sampling n of them first as -create-table
+// does.
+func readRows(t *testing.T, r *rowReader, n int) []map[string]interface{} {
+	t.Helper()
+	if _, err := r.Sample(n); err != nil {
+		t.Fatalf("Sample: %v", err)
+	}
+	return nextRows(t, r)
+}
+
+func TestRowReaderRootPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		path  []string
+		rows  int
+	}{
+		{"array only", `{"data":[{"a":1},{"a":2}]}`, []string{"data"}, 2},
+		{"keys after the array", `{"data":[{"a":1},{"a":2}],"meta":{"page":1},"next":"x"}`, []string{"data"}, 2},
+		{"keys before the array", `{"meta":{"a":[1,2]},"data":[{"a":1}]}`, []string{"data"}, 1},
+		{"nested path", `{"x":1,"data":{"items":[{"a":1},{"a":2}],"total":2},"z":[3]}`, []string{"data", "items"}, 2},
+		{"empty array", `{"data":[],"meta":{}}`, []string{"data"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, n := range []int{0, 1, 1000} {
+				inputs := []io.Reader{strings.NewReader(tt.input), strings.NewReader(tt.input)}
+				r, err := newRowReader(inputs, nil, false, tt.path)
+				if err != nil {
+					t.Fatalf("newRowReader: %v", err)
+				}
+				if rows := readRows(t, r, n); len(rows) != 2*tt.rows {
+					t.Errorf("sample %d: got %d rows, want %d", n, len(rows), 2*tt.rows)
+				}
+			}
+		})
+	}
+}
+
+func TestRowReaderRootPathMissing(t *testing.T) {
+	for _, input := range []string{`{"meta":1}`, `[{"a":1}]`, `{"data":{"a":1}}`} {
+		if _, err := newRowReader([]io.Reader{strings.NewReader(input)}, nil, false, []string{"data"}); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+// newTestReader returns a reader of the JSON inputs.
+func newTestReader(t *testing.T, ndjson bool, inputs ...string) *rowReader {
+	t.Helper()
+	readers := make([]io.Reader, len(inputs))
+	names := make([]string, len(inputs))
+	for i, s := range inputs {
+		readers[i] = strings.NewReader(s)
+		names[i] = fmt.Sprintf("in%d.json", i)
+	}
+	r, err := newRowReader(readers, names, ndjson, nil)
+	if err != nil {
+		t.Fatalf("newRowReader: %v", err)
+	}
+	return r
+}
+
+// ids returns the id key of the rows as text.
+func ids(rows []map[string]interface{}) string {
+	var s []string
+	for _, row := range rows {
+		s = append(s, fmt.Sprint(row["id"]))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestRowReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		ndjson bool
+		inputs []string
+		want   string
+	}{
+		{"array", false, []string{`[{"id":1},{"id":2}]`}, "1,2"},
+		{"empty array", false, []string{`[]`}, ""},
+		{"white space", false, []string{" [ {\"id\":1} ,\n{\"id\":2} ] "}, "1,2"},
+		{"ndjson", true, []string{"{\"id\":1}\n{\"id\":2}\n"}, "1,2"},
+		{"ndjson without newline", true, []string{`{"id":1} {"id":2}`}, "1,2"},
+		{"empty ndjson", true, []string{""}, ""},
+		{"big numbers", false, []string{`[{"id":12345678901234567890.5}]`}, "12345678901234567890.5"},
+		{"several inputs", false, []string{`[{"id":1}]`, `[]`, `[{"id":2},{"id":3}]`}, "1,2,3"},
+		{"several ndjson inputs", true, []string{"{\"id\":1}\n", "{\"id\":2}"}, "1,2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(nextRows(t, newTestReader(t, tt.ndjson, tt.inputs...))); got != tt.want {
+				t.Errorf("got rows %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowReaderNames(t *testing.T) {
+	r := newTestReader(t, false, `[{"id":1}]`, `[{"id":2},{"id":3}]`)
+	if _, err := r.Sample(2); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		if _, err := r.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, r.name)
+	}
+	if got, want := strings.Join(names, ","), "in0.json,in1.json,in1.json"; got != want {
+		t.Errorf("got names %s, want %s", got, want)
+	}
+}
+
+func TestRowReaderInvalid(t *testing.T) {
+	for _, input := range []string{`{"id":1}`, `1`, `"rows"`} {
+		if _, err := newRowReader([]io.Reader{strings.NewReader(input)}, nil, false, nil); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+	r := newTestReader(t, false, `[{"id":1},[2]]`)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Error("an array row: expected an error")
+	}
+}
+
+func TestRowReaderSkip(t *testing.T) {
+	const input = `[{"id":1},{"id":2},{"id":3}]`
+	tests := []struct {
+		skip  int
+		limit int
+		want  string
+	}{
+		{0, 0, "1,2,3"},
+		{1, 0, "2,3"},
+		{3, 0, ""},
+		{5, 0, ""},
+		{1, 1, "2"},
+		{0, 2, "1,2"},
+	}
+	for _, tt := range tests {
+		r := newTestReader(t, false, input)
+		r.limit = tt.limit
+		if err := r.Skip(tt.skip); err != nil {
+			t.Fatalf("Skip(%d): %v", tt.skip, err)
+		}
+		if got := ids(nextRows(t, r)); got != tt.want {
+			t.Errorf("skip %d, limit %d: got rows %s, want %s", tt.skip, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestRowReaderSample(t *testing.T) {
+	const input = `[{"id":1},{"id":2},{"id":3}]`
+	tests := []struct {
+		n      int
+		limit  int
+		sample string
+		rows   string
+	}{
+		{0, 0, "", "1,2,3"},
+		{2, 0, "1,2", "1,2,3"},
+		{10, 0, "1,2,3", "1,2,3"},
+		{1, 2, "1", "1,2"},
+		{3, 2, "1,2", "1,2"},
+	}
+	for _, tt := range tests {
+		r := newTestReader(t, false, input)
+		r.limit = tt.limit
+		sample, err := r.Sample(tt.n)
+		if err != nil {
+			t.Fatalf("Sample(%d): %v", tt.n, err)
+		}
+		if got := ids(sample); got != tt.sample {
+			t.Errorf("Sample(%d) = %s, want %s", tt.n, got, tt.sample)
+		}
+		if got := ids(nextRows(t, r)); got != tt.rows {
+			t.Errorf("after Sample(%d): got rows %s, want %s", tt.n, got, tt.rows)
+		}
+	}
+}
+
+func TestRowReaderSortBy(t *testing.T) {
+	const input = `[
+		{"id":1,"k":{"v":"b"}},
+		{"id":2,"k":{"v":10}},
+		{"id":3},
+		{"id":4,"k":{"v":true}},
+		{"id":5,"k":{"v":9.5}},
+		{"id":6,"k":{"v":"a"}},
+		{"id":7,"k":{"v":false}},
+		{"id":8,"k":{"v":[1]}},
+		{"id":9,"k":{"v":"b"}}
+	]`
+	r := newTestReader(t, false, input)
+	if err := r.SortBy([]string{"k", "v"}); err != nil {
+		t.Fatal(err)
+	}
+	// numbers, strings, booleans, other values and missing ones, stable
+	if got, want := ids(nextRows(t, r)), "5,2,6,1,9,7,4,8,3"; got != want {
+		t.Errorf("got rows %s, want %s", got, want)
+	}
+
+	r = newTestReader(t, false, input)
+	r.limit = 3
+	if err := r.SortBy([]string{"id"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(nextRows(t, r)), "1,2,3"; got != want {
+		t.Errorf("with limit 3: got rows %s, want %s", got, want)
+	}
+}
+
+func TestRowReaderMaxBytes(t *testing.T) {
+	r := newTestReader(t, false, `[{"id":1},{"id":2,"pad":"xxxxxxxxxx"},{"id":3}]`)
+	r.maxBytes = 10
+	if sample, err := r.Sample(2); err != nil {
+		t.Fatal(err)
+	} else if got := ids(sample); got != "1" {
+		t.Errorf("Sample(2) = %s, want 1 without the oversized row", got)
+	}
+	var got []string
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if e, ok := err.(*oversizedRow); ok {
+			if e.size != 27 || e.max != 10 {
+				t.Errorf("got %v, want a row of 27 bytes", e)
+			}
+			got = append(got, "oversized")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(row["id"]))
+	}
+	if want := "1,oversized,3"; strings.Join(got, ",") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, ","), want)
+	}
+
+	r = newTestReader(t, false, `[{"id":1,"pad":"xxxxxxxxxx"},{"id":2}]`)
+	r.maxBytes = 10
+	if err := r.Skip(1); err != nil {
+		t.Fatalf("Skip over an oversized row: %v", err)
+	}
+	if rows := ids(nextRows(t, r)); rows != "2" {
+		t.Errorf("after Skip(1): got rows %s, want 2", rows)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// keyReport describes the values of a JSON key as printed by -inspect.
+type keyReport struct {
+	Key    string `json:"key"`
+	Column string `json:"column,omitempty"`
+	Type   string `json:"type,omitempty"`
+	// Seen counts the values by JSON type
+	Seen map[string]int `json:"seen"`
+	// Failed counts the values that can not be converted to Type, Error
+	// is the error of the first one
+	Failed int    `json:"failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// inspect converts the values of the sampled rows without inserting
+// them and reports, by key, the JSON types seen next to the type of the
+// matching column and the values that would fail.
+func inspect(l *loader, input *rowReader) error {
+	sample, err := input.Sample(*sampleSize)
+	if err != nil {
+		return fmt.Errorf("Failed to decode input data: %v", err)
+	}
+	reports := make(map[string]*keyReport)
+	for _, row := range sample {
+		l.reshape(row)
+		for k, v := range row {
+			r := reports[k]
+			if r == nil {
+				r = &keyReport{Key: k, Seen: make(map[string]int)}
+				if col, ok := l.column(k); ok {
+					r.Column, r.Type = col, l.cols[col]
+					if h, ok := l.hints[col]; ok {
+						r.Type = h
+					}
+				}
+				reports[k] = r
+			}
+			r.Seen[jsonType(v)]++
+			if r.Column == "" {
+				continue
+			}
+			if _, err := l.convert(r.Column, v); err != nil {
+				if r.Failed == 0 {
+					r.Error = err.Error()
+				}
+				r.Failed++
+			}
+		}
+	}
+	keys := make([]string, 0, len(reports))
+	for k := range reports {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]*keyReport, len(keys))
+	failed := 0
+	for i, k := range keys {
+		list[i] = reports[k]
+		failed += reports[k].Failed
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(list)
+	} else {
+		fmt.Printf("Inspected %d rows against %s\n", len(sample), *tableName)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tCOLUMN\tTYPE\tJSON TYPES\tFAILED")
+		for _, r := range list {
+			col, typ := r.Column, r.Type
+			if col == "" {
+				col, typ = "-", "-"
+			}
+			failures := fmt.Sprint(r.Failed)
+			if r.Failed > 0 {
+				failures += " (" + r.Error + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Key, col, typ, seenTypes(r.Seen), failures)
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		return withCode(exitSchema, "Found %d values that can not be converted to their column type", failed)
+	}
+	return nil
+}
+
+// jsonType names the JSON type of a decoded value.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// seenTypes formats the counts of JSON types, e.g. "number 10, null 2".
+func seenTypes(seen map[string]int) string {
+	types := make([]string, 0, len(seen))
+	for t := range seen {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for i, t := range types {
+		types[i] = fmt.Sprintf("%s %d", t, seen[t])
+	}
+	return strings.Join(types, ", ")
+}
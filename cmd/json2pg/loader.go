@@ -0,0 +1,1102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/pkg/errors"
+)
+
+// maxParams is the maximum number of bind parameters postgres accepts
+// in a single statement.
+const maxParams = 65535
+
+// maxPrepared limits the number of statements cached with -prepare.
+// Further statements are run unprepared.
+const maxPrepared = 256
+
+// pendingRow is an input row converted to column names and values
+// ready to be sent to the database.
+type pendingRow struct {
+	id     int
+	fields []string
+	vals   []interface{}
+	// src is the decoded input row
+	src map[string]interface{}
+	// hash identifies the input row with -track-table
+	hash string
+}
+
+// pendingRow sorts by column name, keeping values next to their column.
+func (r pendingRow) Len() int           { return len(r.fields) }
+func (r pendingRow) Less(i, j int) bool { return r.fields[i] < r.fields[j] }
+func (r pendingRow) Swap(i, j int) {
+	r.fields[i], r.fields[j] = r.fields[j], r.fields[i]
+	r.vals[i], r.vals[j] = r.vals[j], r.vals[i]
+}
+
+// rowError is an error caused by a single input row.
+type rowError struct {
+	row int
+	msg string
+}
+
+func (e *rowError) Error() string {
+	return e.msg
+}
+
+// rowErrorf returns a formatted error caused by row.
+func rowErrorf(row int, format string, args ...interface{}) error {
+	return &rowError{row: row, msg: fmt.Sprintf(format, args...)}
+}
+
+// execer is implemented by both *pgx.Conn and *pgx.Tx.
+type execer interface {
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
+	ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error)
+	Prepare(name, sql string) (*pgx.PreparedStatement, error)
+	Query(sql string, args ...interface{}) (*pgx.Rows, error)
+	QueryRow(sql string, args ...interface{}) *pgx.Row
+	CopyFromReader(r io.Reader, sql string) error
+}
+
+// dbConn is what statements and transactions are run on, implemented by
+// *pgx.Conn and by *pgx.ConnPool, which runs each statement on the next
+// free connection of the pool.
+type dbConn interface {
+	execer
+	Begin() (*pgx.Tx, error)
+}
+
+// session is a connection, or the transaction open on it, statements
+// are run on.
+type session struct {
+	db execer
+	tx *pgx.Tx
+	// conn is the connection tx is open on
+	conn dbConn
+	// stmts caches the statements prepared with -prepare by their text,
+	// which only depends on the column list and the number of rows
+	stmts map[string]*pgx.PreparedStatement
+}
+
+// loader inserts rows into a table, grouping rows with the same set of
+// columns into multi-row INSERT statements.
+type loader struct {
+	*session
+	table pgx.Identifier
+	cols  map[string]string
+	// notNull holds the NOT NULL columns, required those without a
+	// default in alphabetical order
+	notNull  map[string]bool
+	required []string
+	// scales holds the scale of numeric columns declared with one
+	scales map[string]int
+	// enums holds the labels of enum columns with -check-enums
+	enums map[string][]string
+	// composites holds the fields of composite type columns
+	composites map[string][]compositeField
+	// rename maps JSON keys to differently named columns
+	rename map[string]string
+	// only restricts the inserted columns if not nil
+	only map[string]bool
+	// folded maps lower case names to columns with -fold-case
+	folded map[string]string
+	// hints overrides the type of columns with -type-hint
+	hints map[string]string
+	// transforms holds the -transform ops by column
+	transforms map[string][]transform
+	// nulls holds the -null-value sentinels by column, "" for all columns
+	nulls map[string]map[string]bool
+	// set holds the values of -set by column, auditFile the column of
+	// -audit-file-column set to the input file of every row
+	set       map[string]interface{}
+	auditFile string
+	// unknown holds the keys without a matching column reported so far
+	unknown map[string]bool
+	// fill is the column set of the first row with -fill-missing
+	fill []string
+	// seen holds the -dedupe-on values read so far, deduped counts the
+	// rows skipped because of them
+	seen    map[string]bool
+	deduped int64
+	// nested holds the child tables of -nested, parent is set for them
+	nested []*nestedTable
+	parent *loader
+	// routes holds the tables of -route by value of the routeField of
+	// the rows, routed lists each table once
+	routes map[string]*routedTable
+	routed []*routedTable
+	// routeTarget is set on the loaders of the routed tables
+	routeTarget bool
+	routeField  string
+
+	// onConflict is "nothing", "update" or empty for a plain INSERT.
+	onConflict   string
+	conflictCols []string
+
+	// errorFile receives the rows that failed with -error-file
+	errorFile *errorFile
+	// pool runs the inserts with -workers
+	pool *pool
+	// tracker skips and records loaded rows with -track-table
+	tracker *tracker
+
+	batch []pendingRow
+
+	// mu guards the totals below once workers run
+	mu       sync.Mutex
+	inserted int64
+	errors   []error
+	// ids holds the values returned with -returning
+	ids []string
+	// commits counts the committed transactions, committed the rows
+	// inserted at the last commit of -commit-every
+	commits   int
+	committed int64
+	// rolledBack is set once the transaction is rolled back on failure
+	rolledBack bool
+	// stopped is set once -max-errors is reached
+	stopped bool
+	// interrupt is closed on SIGINT or SIGTERM, interrupted is set once
+	// loading stopped because of it
+	interrupt   <-chan struct{}
+	interrupted bool
+	// warnedAffected is set once checkAffected warned
+	warnedAffected bool
+
+	// bytesRead is the size of the decoded input
+	bytesRead int64
+
+	// started and reported are used to print progress periodically
+	started  time.Time
+	reported time.Time
+}
+
+// begin starts a transaction all further statements run in.
+func (s *session) begin(pg dbConn) error {
+	tx, err := pg.Begin()
+	if err != nil {
+		return err
+	}
+	s.db, s.tx, s.conn = tx, tx, pg
+	return nil
+}
+
+// load truncates the table if requested and inserts all rows of input,
+// committing the open transaction, if any, on success and rolling it
+// back on failure.
+func (l *loader) load(input *rowReader) error {
+	err := l.loadRows(input)
+	l.bytesRead = input.bytes
+	if l.pool != nil {
+		if err != nil {
+			l.pool.stop(err)
+		}
+		if werr := l.pool.wait(); err == nil {
+			err = werr
+		}
+	}
+	if err == nil && *thenSQL != "" {
+		err = l.then()
+	}
+	if err == nil && l.tx != nil {
+		if err = l.tx.Commit(); err != nil {
+			err = fmt.Errorf("Failed to commit transaction: %v", err)
+		} else {
+			l.commits++
+		}
+	}
+	if err != nil && l.tx != nil {
+		l.tx.Rollback()
+		l.rolledBack = true
+		if l.commits > 0 {
+			log.Printf("Transaction rolled back, keeping the %d rows committed before", l.committed)
+		} else {
+			log.Print("Transaction rolled back")
+			l.ids = nil
+			for _, n := range l.nested {
+				n.inserted = 0
+			}
+			for _, t := range l.routed {
+				t.inserted = 0
+			}
+		}
+		l.inserted = l.committed
+	}
+	return err
+}
+
+// then runs the -then statement once all rows are inserted.
+func (l *loader) then() error {
+	ct, err := l.exec(*thenSQL)
+	if err != nil {
+		return fmt.Errorf("Failed to run -then statement: %s", describeError(err))
+	}
+	if !*dryRun {
+		infof("Ran -then statement: %s", ct)
+	}
+	return nil
+}
+
+// commit commits the rows inserted so far with -commit-every and begins
+// the next transaction.
+func (l *loader) commit() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if err := l.flushRoutes(); err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	if err := l.tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to commit transaction: %v", err)
+	}
+	l.commits++
+	l.committed = l.inserted
+	if err := l.begin(l.conn); err != nil {
+		// nothing is left to roll back
+		l.tx = nil
+		return fmt.Errorf("Failed to begin transaction: %v", err)
+	}
+	return nil
+}
+
+func (l *loader) loadRows(input *rowReader) error {
+	if *truncate {
+		if err := l.truncate(); err != nil {
+			return err
+		}
+	}
+	if *useCopy {
+		// COPY needs every key up front, so the input is read as a whole
+		rows, err := input.ReadAll()
+		if err != nil {
+			return fmt.Errorf("Failed to decode input data: %v", err)
+		}
+		select {
+		case <-l.interrupt:
+			l.interrupted = true
+			return errors.New("Interrupted before COPY")
+		default:
+		}
+		return l.copy(rows)
+	}
+	// row numbers keep counting the skipped rows, so they match the
+	// position in the input
+	for rowID := *skip; ; rowID++ {
+		select {
+		case <-l.interrupt:
+			l.interrupted = true
+			if err := l.flush(); err != nil {
+				return err
+			}
+			if err := l.flushRoutes(); err != nil {
+				return err
+			}
+			return fmt.Errorf("Interrupted before row #%d", rowID)
+		default:
+		}
+		row, err := input.Next()
+		if err == io.EOF {
+			break
+		}
+		if e, ok := err.(*oversizedRow); ok {
+			if err := l.failRow(nil, rowErrorf(rowID, "Skipped row #%d: %v\n", rowID, e)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to decode row #%d: %v", rowID, err)
+		}
+		if l.auditFile != "" {
+			l.set[l.auditFile] = input.name
+		}
+		for _, t := range l.routed {
+			if t.auditFile != "" {
+				t.set[t.auditFile] = input.name
+			}
+		}
+		if err := l.add(rowID, row); err != nil {
+			return err
+		}
+		if *commitEvery > 0 && l.tx != nil && (rowID-*skip+1)%*commitEvery == 0 {
+			if err := l.commit(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := l.flush(); err != nil {
+		return err
+	}
+	return l.flushRoutes()
+}
+
+// count adds n inserted rows to the total, reporting progress to stderr
+// every -progress interval.
+func (l *loader) count(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inserted += n
+	if l.parent != nil || *progress <= 0 || time.Since(l.reported) < *progress {
+		return
+	}
+	l.reported = time.Now()
+	rate := float64(l.inserted) / l.reported.Sub(l.started).Seconds()
+	infof("Inserted %d rows so far (%.0f rows/sec)", l.inserted, rate)
+}
+
+// fail records e and returns nil, or returns e if errors are not
+// ignored. Errors of child tables are recorded by the parent. Once
+// -max-errors errors are recorded, an error stopping the import is
+// returned.
+func (l *loader) fail(e error) error {
+	if !*ignoreErrors {
+		return e
+	}
+	if l.parent != nil {
+		return l.parent.fail(e)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, e)
+	if *maxErrors > 0 && len(l.errors) >= *maxErrors {
+		l.stopped = true
+		if re, ok := e.(*rowError); ok {
+			return fmt.Errorf("Stopped after %d errors at row #%d", len(l.errors), re.row)
+		}
+		return fmt.Errorf("Stopped after %d errors", len(l.errors))
+	}
+	return nil
+}
+
+// failRow writes a row that could not be inserted to the -error-file and
+// records e like fail.
+func (l *loader) failRow(src map[string]interface{}, e error) error {
+	if l.parent != nil {
+		return l.parent.failRow(src, e)
+	}
+	if l.errorFile != nil {
+		if err := l.errorFile.write(src, e); err != nil {
+			return fmt.Errorf("Failed to write to error file: %v", err)
+		}
+	}
+	return l.fail(e)
+}
+
+// exec runs a statement, guarded as described for guard.
+func (s *session) exec(q string, vals ...interface{}) (pgx.CommandTag, error) {
+	if *dryRun {
+		fmt.Printf("%s\nvals: %+v\n\n", q, vals)
+		return "", nil
+	}
+	debugf("%s\nvals: %+v", q, vals)
+	var ct pgx.CommandTag
+	err := s.guard(func() (err error) {
+		ct, err = s.run(q, vals)
+		return err
+	})
+	return ct, err
+}
+
+// queryRow runs a statement returning a single row and scans it into
+// dest.
+func (s *session) queryRow(q string, vals []interface{}, dest ...interface{}) error {
+	if *dryRun {
+		fmt.Printf("%s\nvals: %+v\n\n", q, vals)
+		return nil
+	}
+	debugf("%s\nvals: %+v", q, vals)
+	return s.guard(func() error {
+		return s.db.QueryRow(q, vals...).Scan(dest...)
+	})
+}
+
+// queryColumn runs a statement returning a single text column and
+// returns its values, NULL being returned as "NULL".
+func (s *session) queryColumn(q string, vals []interface{}) ([]string, error) {
+	if *dryRun {
+		fmt.Printf("%s\nvals: %+v\n\n", q, vals)
+		return nil, nil
+	}
+	debugf("%s\nvals: %+v", q, vals)
+	var values []string
+	err := s.guard(func() error {
+		rows, err := s.db.Query(q, vals...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v pgtype.Text
+			if err := rows.Scan(&v); err != nil {
+				return err
+			}
+			if v.Status == pgtype.Present {
+				values = append(values, v.String)
+			} else {
+				values = append(values, "NULL")
+			}
+		}
+		return rows.Err()
+	})
+	return values, err
+}
+
+// guard runs fn. Inside a transaction with ignored errors fn is guarded
+// by a savepoint, so a failed row does not abort the whole transaction.
+func (s *session) guard(fn func() error) error {
+	if s.tx == nil || !*ignoreErrors {
+		return fn()
+	}
+	if _, err := s.tx.Exec("SAVEPOINT json2pg"); err != nil {
+		return errors.Wrap(err, "savepoint failed")
+	}
+	if err := fn(); err != nil {
+		if _, rerr := s.tx.Exec("ROLLBACK TO SAVEPOINT json2pg"); rerr != nil {
+			return errors.Wrap(rerr, "rollback to savepoint failed")
+		}
+		return err
+	}
+	if _, err := s.tx.Exec("RELEASE SAVEPOINT json2pg"); err != nil {
+		return errors.Wrap(err, "release savepoint failed")
+	}
+	return nil
+}
+
+// run executes a statement, preparing it first with -prepare so
+// following statements with the same text skip parsing and planning.
+func (s *session) run(q string, vals []interface{}) (pgx.CommandTag, error) {
+	if !*prepare || len(vals) == 0 {
+		return s.db.Exec(q, vals...)
+	}
+	ps, ok := s.stmts[q]
+	if !ok {
+		if len(s.stmts) >= maxPrepared {
+			return s.db.Exec(q, vals...)
+		}
+		// the name follows from the text, so workers sharing the
+		// connections of -pool prepare a statement under the same name
+		h := fnv.New64a()
+		h.Write([]byte(q))
+		var err error
+		ps, err = s.db.Prepare("json2pg_"+strconv.FormatUint(h.Sum64(), 36), q)
+		if err != nil {
+			return "", err
+		}
+		if s.stmts == nil {
+			s.stmts = make(map[string]*pgx.PreparedStatement)
+		}
+		s.stmts[q] = ps
+	}
+	// a non nil options overrides PreferSimpleProtocol, which would send
+	// the statement name as a query
+	return s.db.ExecEx(context.Background(), ps.Name, &pgx.QueryExOptions{}, vals...)
+}
+
+// truncate empties the table before loading.
+func (l *loader) truncate() error {
+	if _, err := l.exec("TRUNCATE TABLE " + sqlDialect.table(l.table)); err != nil {
+		return fmt.Errorf("Failed to truncate %s: %v", sqlDialect.table(l.table), err)
+	}
+	return nil
+}
+
+// duplicate reports whether the -dedupe-on key of row was seen in an
+// earlier row. Rows without the key are never duplicates.
+func (l *loader) duplicate(row map[string]interface{}) bool {
+	if *dedupeOn == "" || l.parent != nil {
+		return false
+	}
+	v, ok := row[*dedupeOn]
+	if !ok || v == nil {
+		return false
+	}
+	// the JSON encoding tells the number 1 apart from the string "1"
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	if l.seen[string(b)] {
+		l.deduped++
+		return true
+	}
+	l.seen[string(b)] = true
+	return false
+}
+
+// add queues a row for insertion into its table, flushing the current batch when the
+// row does not fit into it.
+func (l *loader) add(rowID int, row map[string]interface{}) error {
+	l.reshape(row)
+	if l.duplicate(row) {
+		return nil
+	}
+	var hash string
+	if l.tracker != nil {
+		if hash = rowHash(row); l.tracker.seen(hash) {
+			return nil
+		}
+	}
+	if t := l.route(row); t != nil {
+		return t.queue(rowID, row, hash)
+	}
+	return l.queue(rowID, row, hash)
+}
+
+// queue converts a row and adds it to the batch of l. hash is recorded
+// with -track-table.
+func (l *loader) queue(rowID int, row map[string]interface{}, hash string) error {
+	if len(l.nested) > 0 {
+		return l.addNested(rowID, row, hash)
+	}
+	r, ok, err := l.prepare(rowID, row)
+	if !ok {
+		return err
+	}
+	r.hash = hash
+	if *fillMissing {
+		if ok, err := l.fillMissing(&r); !ok {
+			return err
+		}
+	}
+	if len(l.batch) > 0 {
+		if !sameFields(l.batch[0].fields, r.fields) || (len(l.batch)+1)*len(r.vals) > maxParams {
+			if err := l.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	l.batch = append(l.batch, r)
+	if len(l.batch) >= *batchSize {
+		return l.flush()
+	}
+	return nil
+}
+
+// column returns the name of the column the JSON key k is inserted into
+// and whether the table has such a column not excluded by -cols.
+func (l *loader) column(k string) (string, bool) {
+	if c, ok := l.rename[k]; ok {
+		k = c
+	}
+	if _, ok := l.cols[k]; !ok && *foldCase {
+		if c := l.foldedColumn(k); c != "" {
+			k = c
+		}
+	}
+	if l.only != nil && !l.only[k] {
+		return k, false
+	}
+	_, ok := l.cols[k]
+	return k, ok
+}
+
+// foldedColumn returns the column matching k ignoring case, or "" if
+// there is none or several columns only differ in case.
+func (l *loader) foldedColumn(k string) string {
+	if l.folded == nil {
+		l.folded = make(map[string]string, len(l.cols))
+		for c := range l.cols {
+			f := strings.ToLower(c)
+			if _, dup := l.folded[f]; dup {
+				l.folded[f] = ""
+			} else {
+				l.folded[f] = c
+			}
+		}
+	}
+	return l.folded[strings.ToLower(k)]
+}
+
+// prepare picks the row values that have a matching table column and
+// converts them into types pgx knows how to encode. It reports false if
+// the row should be skipped and returns a non-nil error if the import
+// should stop.
+func (l *loader) prepare(rowID int, row map[string]interface{}) (pendingRow, bool, error) {
+	r := pendingRow{
+		id:     rowID,
+		fields: make([]string, 0, len(row)),
+		vals:   make([]interface{}, 0, len(row)),
+		src:    row,
+	}
+	keys := make([]string, 0, len(row))
+	for k := range row {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ok := true
+	// failed reports a bad field of the row, which goes to the
+	// -error-file once
+	failed := func(e error) error {
+		first := ok
+		ok = false
+		if first {
+			return l.failRow(row, e)
+		}
+		return l.fail(e)
+	}
+	// keyOf holds the key each column was taken from, as -map, -fold-case
+	// and -flatten can turn several keys into the same column
+	keyOf := make(map[string]string, len(keys))
+	for _, k := range keys {
+		v := row[k]
+		col, found := l.column(k)
+		if _, isSet := l.set[col]; isSet && found {
+			// -set takes precedence over the input
+			continue
+		}
+		if prev, dup := keyOf[col]; dup && found {
+			if err := failed(rowErrorf(rowID, "Keys %s and %s of row #%d both map to column %s\n", prev, k, rowID, col)); err != nil {
+				return r, false, err
+			}
+			continue
+		}
+		if !found {
+			if _, exists := l.cols[col]; !exists && *strict && !l.unknown[k] {
+				if l.unknown == nil {
+					l.unknown = make(map[string]bool)
+				}
+				l.unknown[k] = true
+				if err := l.fail(rowErrorf(rowID, "Key %s of row #%d has no matching column\n", k, rowID)); err != nil {
+					return r, false, err
+				}
+			}
+			continue
+		}
+		var val interface{}
+		var err error
+		if e, isExpr := v.(sqlExpr); isExpr {
+			// the parent id placeholder of -nested with -dry-run
+			val = e
+		} else {
+			val, err = l.convert(col, v)
+		}
+		if err != nil {
+			if err := failed(rowErrorf(rowID, "Failed to convert field %s of row #%d (%T): %v\n", k, rowID, v, err)); err != nil {
+				return r, false, err
+			}
+		}
+		keyOf[col] = k
+		r.fields = append(r.fields, col)
+		r.vals = append(r.vals, val)
+	}
+	for _, col := range l.setColumns() {
+		r.fields = append(r.fields, col)
+		r.vals = append(r.vals, l.set[col])
+	}
+	// renamed keys may be out of order; a stable column order keeps
+	// statements reproducible and lets rows with the same columns share
+	// a batch
+	sort.Sort(r)
+	if ok {
+		if e := l.checkRequired(r); e != nil {
+			return r, false, l.failRow(row, e)
+		}
+	}
+	return r, ok, nil
+}
+
+// checkRequired returns an error for the first NOT NULL column r sets
+// to NULL or the first missing column without a default, before the
+// server rejects the row.
+func (l *loader) checkRequired(r pendingRow) error {
+	for i, f := range r.fields {
+		if r.vals[i] == nil && l.notNull[f] {
+			return rowErrorf(r.id, "Row #%d has NULL for required column %s\n", r.id, f)
+		}
+	}
+	i := 0
+	for _, c := range l.required {
+		// both lists are sorted
+		for i < len(r.fields) && r.fields[i] < c {
+			i++
+		}
+		if i == len(r.fields) || r.fields[i] != c {
+			return rowErrorf(r.id, "Row #%d is missing required column %s\n", r.id, c)
+		}
+	}
+	return nil
+}
+
+// convert turns a decoded JSON value into the value inserted into col,
+// applying -null-value, -empty-as-null and -transform before coercing
+// it to the column type.
+func (l *loader) convert(col string, v interface{}) (interface{}, error) {
+	typ := l.cols[col]
+	if h, ok := l.hints[col]; ok {
+		typ = h
+	}
+	if isSentinel(l.nulls, col, v) {
+		v = nil
+	}
+	if *emptyAsNull && v == "" && !textTypes[typ] {
+		v = nil
+	}
+	val, err := v, error(nil)
+	for _, t := range l.transforms[col] {
+		if val, err = t(val); err != nil {
+			return nil, err
+		}
+	}
+	if labels, isEnum := l.enums[col]; isEnum && typ == l.cols[col] {
+		if err := checkEnum(labels, val); err != nil {
+			return nil, err
+		}
+	}
+	if obj, isObject := val.(map[string]interface{}); isObject && typ == l.cols[col] {
+		if fields, ok := l.composites[col]; ok {
+			return toComposite(fields, obj)
+		}
+	}
+	if val, err = coerce(typ, val); err != nil {
+		return nil, err
+	}
+	if n, isNumeric := val.(*pgtype.Numeric); isNumeric {
+		if scale, ok := l.scales[col]; ok {
+			val = roundNumeric(n, scale)
+		}
+	}
+	return val, nil
+}
+
+// fillMissing adds NULL values for the columns of the first row that r
+// lacks. Missing NOT NULL columns are reported as an error instead. With
+// -explicit-null DEFAULT is added instead of NULL, so only keys given as
+// null are NULL.
+func (l *loader) fillMissing(r *pendingRow) (bool, error) {
+	if l.fill == nil {
+		l.fill = r.fields
+		return true, nil
+	}
+	have := make(map[string]bool, len(r.fields))
+	for _, f := range r.fields {
+		have[f] = true
+	}
+	for _, f := range l.fill {
+		if have[f] {
+			continue
+		}
+		if *explicitNull {
+			// required columns without a default are checked by prepare
+			r.fields = append(r.fields, f)
+			r.vals = append(r.vals, sqlExpr("DEFAULT"))
+			continue
+		}
+		if l.notNull[f] {
+			return false, l.failRow(r.src, rowErrorf(r.id, "Row #%d is missing NOT NULL column %s\n", r.id, f))
+		}
+		r.fields = append(r.fields, f)
+		r.vals = append(r.vals, nil)
+	}
+	sort.Sort(*r)
+	return true, nil
+}
+
+// sameFields reports whether two sorted column lists are equal.
+func sameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// flush sends all queued rows to the database, or hands them over to
+// the workers.
+func (l *loader) flush() error {
+	batch := l.batch
+	l.batch = nil
+	if len(batch) == 0 {
+		return nil
+	}
+	if l.pool != nil {
+		return l.pool.send(batch)
+	}
+	return l.insertBatch(l.session, batch)
+}
+
+// flushRoutes inserts the rows queued for the tables of -route.
+func (l *loader) flushRoutes() error {
+	for _, t := range l.routed {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertBatch inserts rows with a single statement. If the statement
+// fails and errors are ignored, the rows are retried one by one so a
+// single bad row does not drop the whole batch.
+func (l *loader) insertBatch(s *session, batch []pendingRow) error {
+	if len(batch) == 1 {
+		return l.insert(s, batch)
+	}
+	q, _, err := l.execInsert(s, batch)
+	if err != nil {
+		if !*ignoreErrors {
+			return fmt.Errorf("Failed to insert rows #%d-#%d: %s\n\nquery: %s\n", batch[0].id, batch[len(batch)-1].id, describeError(err), q)
+		}
+		for i := range batch {
+			if err := l.insert(s, batch[i:i+1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insert inserts a single row.
+func (l *loader) insert(s *session, batch []pendingRow) error {
+	q, vals, err := l.execInsert(s, batch)
+	if err != nil {
+		if isNoPartition(err) {
+			return l.failRow(batch[0].src, rowErrorf(batch[0].id, "No partition of %s accepts row #%d: %s\n\nvals: %+v\n", sqlDialect.table(l.table), batch[0].id, describeError(err), vals))
+		}
+		return l.failRow(batch[0].src, rowErrorf(batch[0].id, "Failed to insert row #%d: %s\n\nquery: %s\n\nvals: %+v\n", batch[0].id, describeError(err), q, vals))
+	}
+	return nil
+}
+
+// execInsert inserts the rows, counting them and collecting the values
+// returned with -returning. The statement and its values are returned
+// for error messages.
+func (l *loader) execInsert(s *session, batch []pendingRow) (string, []interface{}, error) {
+	q, vals := l.insertQuery(batch)
+	// child tables of -nested return nothing
+	if *returning == "" || (l.parent != nil && !l.routeTarget) {
+		ct, err := s.exec(q, vals...)
+		if err == nil {
+			l.checkAffected(ct.RowsAffected(), len(batch))
+			l.count(ct.RowsAffected())
+		}
+		return q, vals, err
+	}
+	q += " RETURNING " + sqlDialect.quote(*returning) + "::text"
+	ids, err := s.queryColumn(q, vals)
+	if err == nil {
+		l.returned(ids)
+	}
+	return q, vals, err
+}
+
+// checkAffected warns once if fewer rows than sent were reported as
+// inserted without ON CONFLICT. The rows are most likely redirected by
+// a trigger, as done with inheritance based partitioning, and the
+// counts reported by the import are too low.
+func (l *loader) checkAffected(affected int64, sent int) {
+	if *dryRun || l.onConflict != "" || affected >= int64(sent) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.warnedAffected {
+		l.warnedAffected = true
+		infof("INSERT into %s reported %d of %d rows, a trigger may redirect rows to other tables", sqlDialect.table(l.table), affected, sent)
+	}
+}
+
+// returned records the values returned by an INSERT with -returning,
+// those of routed tables with the parent.
+func (l *loader) returned(ids []string) {
+	root := l
+	if l.parent != nil {
+		root = l.parent
+	}
+	root.mu.Lock()
+	root.ids = append(root.ids, ids...)
+	root.mu.Unlock()
+	l.count(int64(len(ids)))
+}
+
+// insertQuery builds an INSERT statement for rows sharing the column
+// list of the first row, along with the flattened list of values.
+func (l *loader) insertQuery(batch []pendingRow) (string, []interface{}) {
+	fields := make([]string, len(batch[0].fields))
+	for i, f := range batch[0].fields {
+		fields[i] = sqlDialect.quote(f)
+	}
+	vals := make([]interface{}, 0, len(batch)*len(fields))
+	rows := make([]string, len(batch))
+	for i, r := range batch {
+		placeholders := make([]string, len(r.vals))
+		for j, v := range r.vals {
+			// expressions of -set and the DEFAULT of -explicit-null are
+			// part of the statement
+			if e, ok := v.(sqlExpr); ok {
+				placeholders[j] = string(e)
+				continue
+			}
+			vals = append(vals, v)
+			placeholders[j] = sqlDialect.placeholder(len(vals))
+			// GeoJSON is converted by PostGIS, so the value is wrapped
+			if _, ok := v.(geoJSON); ok {
+				placeholders[j] = "ST_GeomFromGeoJSON(" + placeholders[j] + "::text)"
+				if l.cols[batch[0].fields[j]] == "geography" {
+					placeholders[j] += "::geography"
+				}
+			}
+		}
+		rows[i] = "(" + strings.Join(placeholders, ",") + ")"
+	}
+	var override string
+	if *overriding != "" {
+		override = " OVERRIDING " + strings.ToUpper(*overriding) + " VALUE"
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (%s)%s VALUES %s`, sqlDialect.table(l.table), strings.Join(fields, ","), override, strings.Join(rows, ","))
+	// rows of child tables of -nested have no hash, routed rows are
+	// recorded by the tracker of the parent
+	tracker := l.tracker
+	if l.parent != nil {
+		tracker = l.parent.tracker
+	}
+	if tracker != nil && batch[0].hash != "" {
+		q = tracker.with(batch) + q
+	}
+	return q + l.conflictClause(batch[0].fields), vals
+}
+
+// conflictClause returns the ON CONFLICT clause for a row with the given
+// fields. Rows without any field besides the conflict columns have
+// nothing to update and are skipped instead.
+func (l *loader) conflictClause(fields []string) string {
+	if l.onConflict == "" {
+		return ""
+	}
+	var target string
+	if len(l.conflictCols) > 0 {
+		quoted := make([]string, len(l.conflictCols))
+		for i, c := range l.conflictCols {
+			quoted[i] = sqlDialect.quote(c)
+		}
+		target = " (" + strings.Join(quoted, ",") + ")"
+	}
+	var set []string
+	if l.onConflict == "update" {
+		skip := make(map[string]bool, len(l.conflictCols))
+		for _, c := range l.conflictCols {
+			skip[c] = true
+		}
+		for _, f := range fields {
+			if !skip[f] {
+				set = append(set, sqlDialect.quote(f)+"=EXCLUDED."+sqlDialect.quote(f))
+			}
+		}
+	}
+	if len(set) == 0 {
+		return " ON CONFLICT" + target + " DO NOTHING"
+	}
+	return " ON CONFLICT" + target + " DO UPDATE SET " + strings.Join(set, ",")
+}
+
+// copy loads rows with the COPY protocol. COPY has no equivalent of
+// ON CONFLICT and aborts on the first bad row, so every row is sent with
+// the same column list and keys missing from a row are sent as NULL.
+func (l *loader) copy(rows []map[string]interface{}) error {
+	for _, row := range rows {
+		l.reshape(row)
+	}
+	fields := l.copyColumns(rows)
+	idx := make(map[string]int, len(fields))
+	for i, f := range fields {
+		idx[f] = i
+	}
+	src := make([][]interface{}, 0, len(rows))
+	for i, row := range rows {
+		if l.duplicate(row) {
+			continue
+		}
+		r, _, err := l.prepare(*skip+i, row)
+		if err != nil {
+			return err
+		}
+		vals := make([]interface{}, len(fields))
+		for i, f := range r.fields {
+			vals[idx[f]] = r.vals[i]
+		}
+		src = append(src, vals)
+	}
+	if *dryRun {
+		var with string
+		if *freeze {
+			with = " WITH (FREEZE)"
+		}
+		fmt.Printf("COPY %s (%s) FROM STDIN%s\n", sqlDialect.table(l.table), strings.Join(fields, ","), with)
+		for _, vals := range src {
+			fmt.Printf("vals: %+v\n", vals)
+		}
+		return nil
+	}
+	return l.copyRows(fields, src)
+}
+
+// copyRows copies rows in the COPY text format, in which postgres parses
+// the values like those of an INSERT. The binary format pgx copies in
+// takes the JSON text of jsonb columns, or the strings of uuid and inet
+// ones, for their binary encoding. With -freeze the rows are written
+// frozen as if VACUUM FREEZE had run, which the server only accepts
+// for a table created or truncated in the current transaction.
+func (l *loader) copyRows(fields []string, src [][]interface{}) error {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = sqlDialect.quote(f)
+	}
+	q := fmt.Sprintf("COPY %s (%s) FROM STDIN", sqlDialect.table(l.table), strings.Join(quoted, ","))
+	if *freeze {
+		q += " WITH (FREEZE)"
+	}
+	buf, err := copyText(src)
+	if err != nil {
+		return fmt.Errorf("Failed to encode rows: %v", err)
+	}
+	debugf("%s, %d rows", q, len(src))
+	if err := l.db.CopyFromReader(buf, q); err != nil {
+		return fmt.Errorf("Failed to copy rows: %s", describeError(err))
+	}
+	l.count(int64(len(src)))
+	return nil
+}
+
+// copyColumns returns the sorted list of columns matching the keys found
+// in rows.
+func (l *loader) copyColumns(rows []map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	fields := make([]string, 0, len(l.cols))
+	for _, row := range rows {
+		for k := range row {
+			if col, ok := l.column(k); ok && !seen[col] {
+				seen[col] = true
+				fields = append(fields, col)
+			}
+		}
+	}
+	for _, col := range l.setColumns() {
+		if !seen[col] {
+			fields = append(fields, col)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
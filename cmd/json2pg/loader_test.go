@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// fakeDB records the statements run by a loader.
+type fakeDB struct {
+	stmts []string
+	args  [][]interface{}
+	// copied holds the data sent with CopyFromReader
+	copied []string
+}
+
+func (db *fakeDB) Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error) {
+	return db.ExecEx(context.Background(), sql, nil, arguments...)
+}
+
+func (db *fakeDB) ExecEx(ctx context.Context, sql string, options *pgx.QueryExOptions, arguments ...interface{}) (pgx.CommandTag, error) {
+	db.stmts = append(db.stmts, sql)
+	db.args = append(db.args, arguments)
+	return "INSERT 0 1", nil
+}
+
+func (db *fakeDB) Prepare(name, sql string) (*pgx.PreparedStatement, error) {
+	return nil, errors.New("not supported")
+}
+
+func (db *fakeDB) Query(sql string, args ...interface{}) (*pgx.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (db *fakeDB) QueryRow(sql string, args ...interface{}) *pgx.Row {
+	panic("not supported")
+}
+
+func (db *fakeDB) CopyFromReader(r io.Reader, sql string) error {
+	b, err := ioutil.ReadAll(r)
+	db.stmts = append(db.stmts, sql)
+	db.copied = append(db.copied, string(b))
+	return err
+}
+
+// newTestLoader returns a loader of the table t with the columns cols.
+func newTestLoader(db *fakeDB, cols map[string]string) *loader {
+	return &loader{
+		session: &session{db: db},
+		table:   pgx.Identifier{"public", "t"},
+		cols:    cols,
+	}
+}
+
+func TestLoaderCopy(t *testing.T) {
+	db := &fakeDB{}
+	l := newTestLoader(db, map[string]string{"id": "integer", "doc": "jsonb", "uid": "uuid", "addr": "inet", "tags": "text[]", "span": "interval"})
+	rows := []map[string]interface{}{
+		{"id": json.Number("1"), "doc": map[string]interface{}{"a": "x\ty"}, "uid": "0123456789abcdef0123456789ABCDEF", "addr": "10.0.0.1", "span": "P1D"},
+		{"id": json.Number("2"), "tags": []interface{}{"a", nil}},
+	}
+	if err := l.copy(rows); err != nil {
+		t.Fatal(err)
+	}
+	if want := `COPY "public"."t" ("addr","doc","id","span","tags","uid") FROM STDIN`; len(db.stmts) != 1 || db.stmts[0] != want {
+		t.Fatalf("got statements %q, want %q", db.stmts, want)
+	}
+	want := "10.0.0.1\t{\"a\":\"x\\\\ty\"}\\n\t1\t1 day 00:00:00.000000\t\\N\t01234567-89ab-cdef-0123-456789abcdef\n" +
+		"\\N\t\\N\t2\t\\N\t{\"a\",NULL}\t\\N\n"
+	if db.copied[0] != want {
+		t.Errorf("copied %q, want %q", db.copied[0], want)
+	}
+	if l.inserted != 2 {
+		t.Errorf("inserted %d rows, want 2", l.inserted)
+	}
+}
+
+func TestLoaderPrepareSameColumn(t *testing.T) {
+	defer func(v bool) { *ignoreErrors = v }(*ignoreErrors)
+	*ignoreErrors = true
+	l := newTestLoader(&fakeDB{}, map[string]string{"id": "integer", "user_id": "integer"})
+	l.rename = map[string]string{"uid": "user_id"}
+	r, ok, err := l.prepare(3, map[string]interface{}{"id": json.Number("1"), "uid": json.Number("2"), "user_id": json.Number("3")})
+	if err != nil || ok {
+		t.Fatalf("got %v, %v, want a failed row", ok, err)
+	}
+	if want := "Keys uid and user_id of row #3 both map to column user_id\n"; len(l.errors) != 1 || l.errors[0].Error() != want {
+		t.Errorf("got errors %q, want %q", l.errors, want)
+	}
+	for i, f := range r.fields {
+		for _, g := range r.fields[i+1:] {
+			if f == g {
+				t.Errorf("column %s given twice in %q", f, r.fields)
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "log"
+
+// infof logs a status message to stderr unless -q is given.
+func infof(format string, args ...interface{}) {
+	if !*quiet {
+		log.Printf(format, args...)
+	}
+}
+
+// debugf logs a message to stderr with -v only.
+func debugf(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
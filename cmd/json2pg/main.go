@@ -1,158 +1,792 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"reflect"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
 	"github.com/pkg/errors"
 )
 
 var (
-	pgUser       = flag.String("U", "root", "Postgres user")
-	pgPassword   = flag.String("P", "", "Postgres password")
-	pgHost       = flag.String("h", "localhost", "Postgres host")
-	pgPort       = flag.Uint("p", 5432, "Postgres port")
-	databaseName = flag.String("d", "", "Database name")
-	tableName    = flag.String("t", "", "Table name")
-	fileName     = flag.String("f", "", "Input file name")
-	ignoreErrors = flag.Bool("ignore-errors", false, "Ignore insert errors")
+	configFile       = flag.String("config", "", "JSON file with settings keyed by flag name, overridden by the command line")
+	pgUser           = flag.String("U", "root", "Postgres user (env PGUSER)")
+	pgPassword       = flag.String("P", "", "Postgres password (env PGPASSWORD)")
+	pgHost           = flag.String("h", "localhost", "Postgres host or unix socket directory (env PGHOST)")
+	pgPort           = flag.Uint("p", 5432, "Postgres port (env PGPORT)")
+	databaseName     = flag.String("d", "", "Database name (env PGDATABASE)")
+	sslMode          = flag.String("sslmode", "prefer", "SSL mode: disable, allow, prefer, require, verify-ca or verify-full (ignored with -dsn)")
+	sslRootCert      = flag.String("sslrootcert", "", "CA certificate file for verify-ca and verify-full")
+	dsn              = flag.String("dsn", "", "Connection URI or DSN, overrides -U, -P, -h, -p and -d")
+	dialectName      = flag.String("dialect", "postgres", "Database speaking the postgres protocol: "+strings.Join(dialectNames(), ", "))
+	appName          = flag.String("app-name", "json2pg", "Application name shown in pg_stat_activity")
+	role             = flag.String("role", "", "Role to SET ROLE to after connecting, so rows are inserted with its privileges and row level security policies")
+	runtimeParams    = repeatVar("param", "Run time parameter as key=value set on the connection, e.g. search_path=app,public, may be repeated")
+	statementTimeout = flag.Duration("statement-timeout", 0, "Abort statements running longer, e.g. an INSERT blocked on a lock, 0 for the server default")
+	connectTimeout   = flag.Duration("connect-timeout", 0, "Timeout of a single connection attempt, 0 for none")
+	connectRetries   = flag.Int("connect-retries", 0, "Number of times to retry connecting with exponential backoff")
+	simpleProtocol   = flag.Bool("simple-protocol", true, "Use the simple query protocol, needed behind pgbouncer in transaction mode; disable on direct connections to send values in binary")
+	tableName        = flag.String("t", "", "Table name, optionally schema qualified")
+	inferTable       = flag.Bool("infer-table-from-filename", false, "Take the table, optionally schema qualified, from the input file name if -t is not given, e.g. public.orders.json")
+	schemaName       = flag.String("schema", "public", "Schema of the table unless -t is schema qualified")
+	fileName         = flag.String("f", "", "Comma separated input file names or http(s) URLs, more may follow the flags (stdin if empty or -)")
+	zipFile          = flag.String("zip", "", "Read the input from the entries of a zip archive instead of -f")
+	zipEntry         = flag.String("entry", "*", "Pattern selecting the -zip entries to read, e.g. orders.json or data/*.json, matched against the full and the base name")
+	httpTimeout      = flag.Duration("timeout", 30*time.Second, "Timeout for connecting to -f http(s) URLs and receiving the response headers, 0 for none")
+	allowEmpty       = flag.Bool("allow-empty", false, "Succeed with 0 rows inserted if the input holds no rows")
+	ignoreErrors     = flag.Bool("ignore-errors", false, "Ignore insert errors")
+	errorFileName    = flag.String("error-file", "", "Write rows that failed as JSON lines holding the row number, error and input row")
+	maxErrors        = flag.Int("max-errors", 0, "Stop after N errors with -ignore-errors, 0 for no limit")
+	maxRowBytes      = flag.Int("max-row-bytes", 0, "Skip rows whose JSON is larger than N bytes as failed, 0 for no limit")
+	batchSize        = flag.Int("batch", 100, "Number of rows per INSERT statement")
+	skip             = flag.Int("skip", 0, "Skip the first N rows of the input")
+	limit            = flag.Int("limit", 0, "Load only the first N rows, 0 or less for all rows")
+	sortBy           = flag.String("sort-by", "", "Insert the rows ordered by this key, or dotted path, e.g. to match a clustered or BRIN index; all rows are read into memory first")
+	gzipInput        = flag.Bool("gzip", false, "Input is gzip compressed (implied by a .gz file name)")
+	ndjson           = flag.Bool("ndjson", false, "Input is newline delimited JSON objects instead of an array")
+	rootKey          = flag.String("root-path", "", "Dotted path of the array of rows in an object wrapping it, e.g. data for {\"data\": [...], \"meta\": {...}}")
+	prepare          = flag.Bool("prepare", false, "Prepare each distinct INSERT statement once and reuse it (not usable through pgbouncer in transaction mode)")
+	workers          = flag.Int("workers", 1, "Number of connections inserting batches concurrently (not compatible with -tx)")
+	connPool         = flag.Bool("pool", false, "Insert with -workers through a pool of connections, replacing broken ones, instead of a fixed connection per worker")
+	useTx            = flag.Bool("tx", false, "Run the whole import in a single transaction")
+	commitEvery      = flag.Int("commit-every", 0, "Commit and begin a new transaction every N input rows with -tx, keeping the rows committed so far on failure")
+	columnMap        = listVar("map", "Comma separated jsonKey:column pairs to insert keys into differently named columns, jsonKey may be a dotted path into nested objects, e.g. user.name:user_name, may be repeated")
+	flatten          = flag.Bool("flatten", false, "Insert the keys of nested objects without a column of their own into columns named by their path joined with _, e.g. user.name into user_name")
+	noAutoJSON       = flag.Bool("no-auto-json", false, "Only insert JSON objects and arrays into json and jsonb columns instead of encoding them as JSON for any column; with -flatten objects are then flattened unless their column is of a type made from objects")
+	nested           = listVar("nested", "Comma separated key:table:fk triples inserting the objects under key into table with the id of the parent row in column fk, may be repeated (disables batching)")
+	routeRows        = listVar("route", "Comma separated field:value=table items inserting rows whose field holds value into table instead of -t, may be repeated")
+	returning        = flag.String("returning", "", "Column returned by every INSERT and listed in the summary, e.g. id")
+	typeHints        = listVar("type-hint", "Comma separated column:kind pairs converting values as kind, one of json, timestamp, date, array, uuid, bool, numeric, integer, float or text, regardless of the column type, may be repeated")
+	transforms       = listVar("transform", "Comma separated column:op items changing values before insertion, op being uppercase, lowercase, trim, multiply:N or divide:N, may be repeated")
+	setValues        = repeatVar("set", "Insert column=value into every row, replacing the value of the input, or column:=expression for an SQL expression, e.g. imported_at:=now(), may be repeated")
+	auditFileColumn  = flag.String("audit-file-column", "", "Column set to the input file name of every row, skipped if the table has no such column")
+	auditBatchColumn = flag.String("audit-batch-column", "", "Column set to a UUID generated for the import, skipped if the table has no such column")
+	nullValues       = repeatVar("null-value", "Value inserted as NULL, e.g. N/A or -1, or column:value for a single column (:value for a value holding a colon), may be repeated")
+	onlyCols         = flag.String("cols", "", "Comma separated list of columns to insert, others keep their defaults")
+	fillMissing      = flag.Bool("fill-missing", false, "Insert NULL for columns of the first row missing from later rows")
+	explicitNull     = flag.Bool("explicit-null", false, "Insert NULL only for keys given as null and let the column DEFAULT apply to missing keys, also with -fill-missing; not supported by COPY")
+	emptyAsNull      = flag.Bool("empty-as-null", false, "Insert empty strings as NULL into columns other than text columns")
+	timezone         = flag.String("timezone", "", "Time zone of timestamps without an offset and of epoch numbers, e.g. UTC or America/New_York; by default strings are read as UTC and numbers in the local zone")
+	epochUnit        = flag.String("epoch-unit", "s", "Unit of numbers inserted into date and timestamp columns: s, ms, us or ns")
+	foldCase         = flag.Bool("fold-case", false, "Match JSON keys to columns ignoring case, e.g. UserId to userid")
+	checkEnums       = flag.Bool("check-enums", false, "Check strings inserted into enum columns against the labels of the enum, failing the row with the valid labels")
+	schemaCacheFile  = flag.String("schema-cache", "", "File caching the columns of the tables between runs, read instead of querying the database; use -refresh-schema after changing a table")
+	refreshSchema    = flag.Bool("refresh-schema", false, "Read the columns from the database and update the -schema-cache file")
+	strict           = flag.Bool("strict", false, "Fail on JSON keys without a matching column instead of skipping them")
+	dedupeOn         = flag.String("dedupe-on", "", "JSON key whose value identifies a row, skipping later rows with a value already seen; every distinct value is kept in memory")
+	trackTable       = flag.String("track-table", "", "Table, created if needed, recording a hash of every inserted row so a re-run skips the rows already loaded")
+	overriding       = flag.String("overriding", "", "Insert with OVERRIDING SYSTEM VALUE or OVERRIDING USER VALUE, system or user, to keep or replace the values of identity columns")
+	onConflict       = flag.String("on-conflict", "", "Action on unique violation: nothing or update")
+	conflictCols     = flag.String("conflict-cols", "", "Comma separated list of unique columns for -on-conflict")
+	createTable      = flag.Bool("create-table", false, "Create the table from the types of the sampled rows if it does not exist")
+	tempTable        = flag.Bool("temp", false, "Create the table with -create-table as a temporary table, dropped when the import ends; use -then to copy the rows on, e.g. INSERT INTO orders SELECT * FROM staging ON CONFLICT DO NOTHING")
+	thenSQL          = flag.String("then", "", "SQL statement run after all rows are loaded, in the same session and, with -tx, transaction")
+	generateDDL      = flag.Bool("generate-ddl", false, "Print the CREATE TABLE statement inferred from the sampled rows and exit without connecting")
+	sampleSize       = flag.Int("sample", 1000, "Number of rows sampled to infer column types for -create-table")
+	truncate         = flag.Bool("truncate", false, "Truncate the table before loading, within the transaction with -tx")
+	inspectOnly      = flag.Bool("inspect", false, "Only convert the sampled rows and report, by key, the JSON types seen and the values failing to convert to the column type, inserting nothing")
+	countOnly        = flag.Bool("count-only", false, "Only decode the input and report rows, key sets and keys matching the table, inserting nothing")
+	analyze          = flag.Bool("analyze", false, "Run ANALYZE on the table after a load without errors")
+	vacuum           = flag.Bool("vacuum", false, "Run VACUUM ANALYZE on the table after a load without errors")
+	ping             = flag.Bool("ping", false, "Only connect, check that the table exists and may be inserted into and print its columns")
+	verifyCount      = flag.Bool("verify", false, "Count the rows of the table after the load and fail if they differ from the rows inserted, e.g. because a trigger or rule discarded them; counts the -audit-batch-column of the import if set, the whole table otherwise, which other writers must leave alone meanwhile")
+	dryRun           = flag.Bool("dry-run", false, "Print generated statements instead of executing them")
+	progress         = flag.Duration("progress", 5*time.Second, "Interval between progress reports on stderr, 0 to disable")
+	verbose          = flag.Bool("v", false, "Log every statement and the connection details to stderr")
+	quiet            = flag.Bool("q", false, "Only print errors, no progress or summary of inserted rows")
+	jsonOutput       = flag.Bool("json-output", false, "Print the summary as a JSON object")
+	useCopy          = flag.Bool("copy", false, "Load rows with COPY instead of INSERT (not compatible with -ignore-errors and -on-conflict)")
+	freeze           = flag.Bool("freeze", false, "Load rows with COPY FREEZE, skipping the later VACUUM of the rows; needs -copy, -tx and -truncate as PostgreSQL only accepts it for a table emptied in the loading transaction, and not for partitioned tables")
 )
 
+// Exit codes telling configuration and connection problems apart from
+// bad data.
+const (
+	exitData   = 1
+	exitConfig = 2
+	exitSchema = 3
+	// exitInterrupted follows the shell convention for SIGINT
+	exitInterrupted = 130
+)
+
+// exitError is an error that ends the program with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// withCode returns a formatted error ending the program with code.
+func withCode(code int, format string, args ...interface{}) error {
+	return &exitError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// usageError prints the usage and returns a formatted configuration
+// error.
+func usageError(format string, args ...interface{}) error {
+	flag.Usage()
+	return withCode(exitConfig, format, args...)
+}
+
 func main() {
 	flag.Parse()
-	if *databaseName == "" {
-		flag.Usage()
-		log.Fatal("Please specify database name")
+	if err := run(); err != nil {
+		code := exitData
+		if e, ok := err.(*exitError); ok {
+			code = e.code
+		}
+		log.Print(err)
+		os.Exit(code)
+	}
+}
+
+func run() error {
+	if *configFile != "" {
+		if err := loadConfig(*configFile); err != nil {
+			return usageError("Failed to load config: %v", err)
+		}
+	}
+	d, ok := dialects[*dialectName]
+	if !ok {
+		return usageError("Unknown -dialect %q", *dialectName)
+	}
+	sqlDialect = d
+	if *generateDDL {
+		return printDDL()
+	}
+	config, err := connConfig()
+	if err != nil {
+		return usageError("%v", err)
+	}
+	if config.Database == "" {
+		return usageError("Please specify database name")
+	}
+	if *tableName == "" && *inferTable {
+		*tableName = tableFromFile(firstInput())
 	}
 	if *tableName == "" {
-		flag.Usage()
-		log.Fatal("Please specify table name")
-	}
-	if *fileName == "" {
-		flag.Usage()
-		log.Fatal("Please specify input file name")
-	}
-
-	pg, err := pgx.Connect(pgx.ConnConfig{
-		Host:                 *pgHost,
-		User:                 *pgUser,
-		Password:             *pgPassword,
-		Port:                 uint16(*pgPort),
-		Database:             *databaseName,
-		PreferSimpleProtocol: true,
-	})
+		return usageError("Please specify table name")
+	}
+	rename, err := parsePairs(*columnMap, ":")
+	if err != nil {
+		return usageError("Invalid -map: %v", err)
+	}
+	hints, err := parsePairs(*typeHints, ":")
+	if err != nil {
+		return usageError("Invalid -type-hint: %v", err)
+	}
+	for c, kind := range hints {
+		t, ok := hintTypes[kind]
+		if !ok {
+			return usageError("Unknown -type-hint kind %q", kind)
+		}
+		hints[c] = t
+	}
+	changes, err := parseTransforms(*transforms)
+	if err != nil {
+		return usageError("Invalid -transform: %v", err)
+	}
+	children, err := parseNested(*nested)
+	if err != nil {
+		return usageError("Invalid -nested: %v", err)
+	}
+	routeField, routeTables, err := parseRoutes(*routeRows)
+	if err != nil {
+		return usageError("Invalid -route: %v", err)
+	}
+	if *verbose && *quiet {
+		return usageError("-v and -q can not be combined")
+	}
+	if _, ok := epochUnits[*epochUnit]; !ok {
+		return usageError("Unknown -epoch-unit %q", *epochUnit)
+	}
+	if *timezone != "" {
+		if timeZone, err = time.LoadLocation(*timezone); err != nil {
+			return usageError("Invalid -timezone: %v", err)
+		}
+	}
+	if *skip < 0 {
+		return usageError("Invalid -skip %d", *skip)
+	}
+	if *workers < 1 {
+		return usageError("Invalid -workers %d", *workers)
+	}
+	if *commitEvery < 0 {
+		return usageError("Invalid -commit-every %d", *commitEvery)
+	}
+	if *commitEvery > 0 && !*useTx {
+		return usageError("-commit-every needs -tx")
+	}
+	if *refreshSchema && *schemaCacheFile == "" {
+		return usageError("-refresh-schema needs -schema-cache")
+	}
+	if *tempTable && !*createTable {
+		return usageError("-temp needs -create-table, a temporary table only exists in the session of the import")
+	}
+	if *tempTable && strings.Contains(*tableName, ".") {
+		return usageError("-temp tables are created in the temporary schema, -t can not name a schema")
+	}
+	if *freeze && (!*useCopy || !*useTx || !*truncate) {
+		return usageError("-freeze needs -copy, -tx and -truncate")
+	}
+	if *freeze && *commitEvery > 0 {
+		return usageError("-freeze only works in the transaction that truncated the table and can not be combined with -commit-every")
+	}
+	if *connPool && *workers < 2 {
+		return usageError("-pool needs -workers 2 or more")
+	}
+	if *workers > 1 && *useTx {
+		return usageError("-tx runs on a single connection and can not be combined with -workers")
+	}
+	switch *overriding {
+	case "", "system", "user":
+	default:
+		return usageError("Unknown -overriding %q", *overriding)
+	}
+	switch *onConflict {
+	case "", "nothing":
+	case "update":
+		if *conflictCols == "" {
+			return usageError("Please specify -conflict-cols for -on-conflict update")
+		}
+	default:
+		return usageError("Unknown -on-conflict action %q", *onConflict)
+	}
+
+	pg, err := connect(config)
 	if err != nil {
-		log.Fatalf("Failed to connect to db: %v", err)
+		return withCode(exitConfig, "Failed to connect to db: %v", err)
 	}
 	defer pg.Close()
+	if *ping {
+		return pingTable(pg, config)
+	}
 
-	file, err := os.Open(*fileName)
+	input, closeInputs, err := openRows()
+	if err == errNoRows && *allowEmpty {
+		// the table is left as is, even with -truncate
+		*truncate = false
+		return report(&loader{session: &session{db: pg}, started: time.Now()})
+	}
 	if err != nil {
-		log.Fatalf("Failed to open input file for reading: %v", err)
+		return err
 	}
-	defer file.Close()
-	var inputData []map[string]interface{}
-	err = json.NewDecoder(file).Decode(&inputData)
+	defer closeInputs()
+
+	schema, table := targetTable()
+	tc, err := columns(pg, config.Database, schema, table)
 	if err != nil {
-		log.Fatalf("Failed to decode input data: %v", err)
+		return withCode(exitSchema, "Failed to read table structure: %v", err)
 	}
-	if len(inputData) == 0 {
-		log.Fatal("No rows in the input file")
+	cols := tc.types
+	if len(cols) == 0 && *createTable {
+		sample, err := input.Sample(*sampleSize)
+		if err != nil {
+			return fmt.Errorf("Failed to decode input data: %v", err)
+		}
+		cols = sampleColumns(sample, rename, children)
+		q := createTableQuery(pgx.Identifier{schema, table}, cols)
+		if *dryRun {
+			fmt.Printf("%s\n\n", q)
+		} else if !*countOnly && !*inspectOnly {
+			debugf("%s", q)
+			if _, err := pg.Exec(q); err != nil {
+				return withCode(exitSchema, "Failed to create table: %v\n\nquery: %s\n", err, q)
+			}
+			if tc, err = columns(pg, config.Database, schema, table); err != nil {
+				return withCode(exitSchema, "Failed to read table structure: %v", err)
+			}
+			cols = tc.types
+			infof("Created table %s", *tableName)
+		}
 	}
 
-	cols, err := columns(pg, *databaseName, *tableName)
-	if err != nil {
-		log.Fatalf("Failed to read table structure: %v", err)
-	}
-
-	errors := make([]error, 0)
-	var totalInserted int64
-	for rowID, row := range inputData {
-		var valuePlaceholders string
-		fields := make([]string, 0, len(row))
-		vals := make([]interface{}, 0, len(row))
-		var i int
-		for k, v := range row {
-			if _, ok := cols[k]; !ok {
-				continue
+	if len(cols) == 0 {
+		return withCode(exitSchema, "Table %s not found in database %s (schema %s)", table, config.Database, schema)
+	}
+
+	var only map[string]bool
+	if list := splitList(*onlyCols); len(list) > 0 {
+		only = make(map[string]bool, len(list))
+		for _, c := range list {
+			only[c] = true
+		}
+	}
+	nulls := parseNullValues(*nullValues)
+
+	now := time.Now()
+	l := &loader{
+		session:      &session{db: pg},
+		table:        pgx.Identifier{schema, table},
+		cols:         cols,
+		notNull:      tc.notNull,
+		required:     tc.required,
+		scales:       tc.scales,
+		enums:        tc.enums,
+		composites:   tc.composites,
+		rename:       rename,
+		only:         only,
+		hints:        hints,
+		transforms:   changes,
+		nulls:        nulls,
+		onConflict:   *onConflict,
+		conflictCols: splitList(*conflictCols),
+		started:      now,
+		reported:     now,
+	}
+	if err := checkColumns(l, *tableName); err != nil {
+		return err
+	}
+	var batchID string
+	if *auditBatchColumn != "" {
+		if batchID, err = newUUID(); err != nil {
+			return fmt.Errorf("Failed to generate batch id: %v", err)
+		}
+	}
+	if l.set, err = parseSet(l, *tableName, *setValues); err != nil {
+		return usageError("Invalid -set: %v", err)
+	}
+	if err := setAudit(l, *tableName, batchID); err != nil {
+		return usageError("Invalid -audit-batch-column: %v", err)
+	}
+	if *errorFileName != "" && !*countOnly && !*inspectOnly {
+		w, err := createErrorFile(*errorFileName)
+		if err != nil {
+			return withCode(exitConfig, "Failed to create error file: %v", err)
+		}
+		defer w.Close()
+		l.errorFile = w
+	}
+	if err := newNested(pg, config.Database, l, children); err != nil {
+		return withCode(exitSchema, "Failed to read nested table structure: %v", err)
+	}
+	if err := newRoutes(pg, config.Database, l, routeField, routeTables, batchID); err != nil {
+		if _, ok := err.(*exitError); ok {
+			return err
+		}
+		return withCode(exitSchema, "Failed to read routed table structure: %v", err)
+	}
+	if *countOnly {
+		return countRows(l, input)
+	}
+	if *inspectOnly {
+		return inspect(l, input)
+	}
+	if *trackTable != "" {
+		if *prepare {
+			infof("Row hashes make every statement distinct, ignoring -prepare")
+			*prepare = false
+		}
+		if l.tracker, err = newTracker(l, *trackTable); err != nil {
+			return withCode(exitSchema, "Failed to set up -track-table: %v", err)
+		}
+	}
+	if *useCopy && len(children) > 0 {
+		infof("COPY can not return ids, falling back to INSERT because of -nested")
+		*useCopy = false
+	}
+	if *useCopy && len(routeTables) > 0 {
+		infof("COPY loads a single table, falling back to INSERT because of -route")
+		*useCopy = false
+	}
+	if *useCopy && *explicitNull {
+		infof("COPY inserts NULL for missing keys, falling back to INSERT because of -explicit-null")
+		*useCopy = false
+	}
+	if *useCopy && l.hasExpr() {
+		infof("COPY can not evaluate expressions, falling back to INSERT because of -set")
+		*useCopy = false
+	}
+	if *useCopy && l.auditFile != "" {
+		infof("COPY does not tell input files apart, falling back to INSERT because of -audit-file-column")
+		*useCopy = false
+	}
+	if *useCopy && *returning != "" {
+		infof("COPY can not return values, falling back to INSERT because of -returning")
+		*useCopy = false
+	}
+	if *useCopy && hasGeometry(cols) {
+		infof("COPY can not convert GeoJSON, falling back to INSERT because of geometry columns")
+		*useCopy = false
+	}
+	if *useCopy && len(tc.composites) > 0 {
+		infof("COPY can not convert objects to composite types, falling back to INSERT because of composite columns")
+		*useCopy = false
+	}
+	if *useCopy && *ignoreErrors {
+		infof("COPY can not skip bad rows, falling back to INSERT because of -ignore-errors")
+		*useCopy = false
+	}
+	if *useCopy && *onConflict != "" {
+		infof("COPY does not support ON CONFLICT, falling back to INSERT because of -on-conflict")
+		*useCopy = false
+	}
+	if *useCopy && *trackTable != "" {
+		infof("COPY can not record row hashes, falling back to INSERT because of -track-table")
+		*useCopy = false
+	}
+	if *useCopy && *commitEvery > 0 {
+		infof("COPY loads all rows in a single statement, ignoring -commit-every")
+	}
+	if *useCopy && *overriding == "user" {
+		infof("COPY always keeps identity values, falling back to INSERT because of -overriding user")
+		*useCopy = false
+	}
+	if *freeze && !*useCopy {
+		infof("Ignoring -freeze without COPY")
+		*freeze = false
+	}
+	if *useCopy && *workers > 1 {
+		infof("COPY runs on a single connection, ignoring -workers")
+	}
+	if *workers > 1 && len(children) > 0 {
+		infof("Rows with nested objects are inserted one by one, ignoring -workers")
+		*workers = 1
+	}
+	if *workers > 1 && *tempTable {
+		infof("Temporary tables are only visible to their own connection, ignoring -workers")
+		*workers = 1
+	}
+	if *workers > 1 && len(routeTables) > 0 {
+		infof("Routed rows are inserted on a single connection, ignoring -workers")
+		*workers = 1
+	}
+	if *workers > 1 && !*useCopy && !*dryRun {
+		var conns []dbConn
+		if *connPool {
+			cp, err := pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: config, MaxConnections: *workers, AfterConnect: setRole})
+			if err != nil {
+				return withCode(exitConfig, "Failed to connect to db: %v", err)
 			}
-			i++
-			if i > 1 {
-				valuePlaceholders += ","
+			defer cp.Close()
+			for len(conns) < *workers {
+				conns = append(conns, cp)
 			}
-			valuePlaceholders += "$" + strconv.Itoa(i)
-			fields = append(fields, `"`+k+`"`)
-
-			if v != nil {
-				switch {
-				// handle number -> timestamp
-				case reflect.TypeOf(v).Kind() == reflect.Float64 && strings.Contains(cols[k], "timestamp"):
-					v = time.Unix(int64(v.(float64)), 0)
-				// handle json/jsonb
-				case reflect.TypeOf(v).Kind() == reflect.Map:
-					b := bytes.NewBuffer(nil)
-					err = json.NewEncoder(b).Encode(v)
-					if err != nil {
-						e := fmt.Errorf("Failed to encode json field %s: %v\n", k, err)
-						if !*ignoreErrors {
-							log.Fatal(e.Error())
-						}
-						errors = append(errors, e)
-					}
-					v = b.String()
+		} else {
+			conns = append(conns, pg)
+			for len(conns) < *workers {
+				c, err := connect(config)
+				if err != nil {
+					return withCode(exitConfig, "Failed to connect to db: %v", err)
 				}
+				defer c.Close()
+				conns = append(conns, c)
 			}
-			vals = append(vals, v)
 		}
-		q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, *tableName, strings.Join(fields, ","), valuePlaceholders)
-		ct, err := pg.Exec(q, vals...)
-		if err != nil {
-			e := fmt.Errorf("Failed to insert row #%d: %v\n\nquery: %s\n\nvals: %+v\n", rowID, err, q, vals)
-			if !*ignoreErrors {
-				log.Fatal(e.Error())
-			}
-			errors = append(errors, e)
+		l.pool = startPool(l, conns)
+	}
+	var verify *verifier
+	if *verifyCount && !*dryRun {
+		if verify, err = newVerifier(pg, l); err != nil {
+			return err
 		}
-		totalInserted += ct.RowsAffected()
 	}
-	fmt.Printf("Inserted %d rows into %s\n", totalInserted, *tableName)
-	if len(errors) > 0 {
-		fmt.Printf("Errors occured during execution (%d):\n", len(errors))
-		for i, err := range errors {
-			fmt.Printf("#%d\n%s\n", i, err)
+	if *useTx {
+		if err := l.begin(pg); err != nil {
+			return withCode(exitConfig, "Failed to begin transaction: %v", err)
+		}
+	}
+	l.interrupt = notifyInterrupt()
+	if err := l.load(input); err != nil {
+		// without a transaction the rows inserted so far are kept, a
+		// rolled back one is reported as such
+		if l.rolledBack || ((l.stopped || l.interrupted) && l.tx == nil) {
+			report(l)
+		}
+		if l.interrupted {
+			return &exitError{code: exitInterrupted, err: err}
 		}
-		os.Exit(1)
+		return err
 	}
+	var verifyErr error
+	if verify != nil {
+		verifyErr = verify.check(pg, l)
+	}
+	if *analyze || *vacuum {
+		analyzeTable(pg, l)
+	}
+
+	if err := report(l); err != nil {
+		return err
+	}
+	return verifyErr
 }
 
-func columns(pg *pgx.Conn, dbName, tableName string) (map[string]string, error) {
+// hasGeometry reports whether any column is a PostGIS type.
+func hasGeometry(cols map[string]string) bool {
+	for _, t := range cols {
+		if t == "geometry" || t == "geography" {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyInterrupt returns a channel closed on the first SIGINT or
+// SIGTERM. A second signal terminates the program right away.
+func notifyInterrupt() <-chan struct{} {
+	interrupt := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		log.Printf("Received %v, stopping after the current batch", sig)
+		close(interrupt)
+	}()
+	return interrupt
+}
+
+// analyzeTable refreshes the planner statistics of the table after a
+// complete load. Failing to do so does not fail the import.
+func analyzeTable(pg *pgx.Conn, l *loader) {
+	q := "ANALYZE " + sqlDialect.table(l.table)
+	if *vacuum && !sqlDialect.vacuum {
+		infof("The database has no VACUUM, running ANALYZE only")
+	} else if *vacuum {
+		q = "VACUUM " + q
+	}
+	if len(l.errors) > 0 {
+		infof("Skipping %s because of errors during the import", q)
+		return
+	}
+	if *dryRun {
+		fmt.Printf("%s\n\n", q)
+		return
+	}
+	debugf("%s", q)
+	if _, err := pg.Exec(q); err != nil {
+		log.Printf("Failed to run %s: %v", q, err)
+	}
+}
+
+// tableFromFile returns the table name of an input file named after it,
+// dropping the directory and the extensions, or "" for stdin.
+func tableFromFile(name string) string {
+	if name == "" || name == "-" {
+		return ""
+	}
+	if u, ok := inputURL(name); ok {
+		name = u.Path
+	}
+	name = filepath.Base(name)
+	name = strings.TrimSuffix(name, ".zip")
+	name = strings.TrimSuffix(name, ".gz")
+	for _, ext := range []string{".json", ".ndjson", ".jsonl"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// checkColumns returns a usage error for a column given with -cols,
+// -transform, -type-hint, -null-value or -returning that the table of l,
+// called name, lacks.
+func checkColumns(l *loader, name string) error {
+	given := make(map[string]string)
+	for c := range l.only {
+		given[c] = "-cols"
+	}
+	for c := range l.transforms {
+		given[c] = "-transform"
+	}
+	for c := range l.hints {
+		given[c] = "-type-hint"
+	}
+	for c := range l.nulls {
+		// a -null-value without a column applies to all of them
+		if c != "" {
+			given[c] = "-null-value"
+		}
+	}
+	for _, c := range l.conflictCols {
+		given[c] = "-conflict-cols"
+	}
+	if *returning != "" {
+		given[*returning] = "-returning"
+	}
+	missing := make([]string, 0, len(given))
+	for c := range given {
+		if _, ok := l.cols[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return usageError("Column %s given in %s does not exist in %s", missing[0], given[missing[0]], name)
+}
+
+// targetTable returns the schema and name of the table loaded into, in
+// the pg_temp schema of the session with -temp.
+func targetTable() (schema, table string) {
+	schema, table = splitTable(*tableName, *schemaName)
+	if *tempTable {
+		schema = "pg_temp"
+	}
+	return schema, table
+}
+
+// splitTable splits a "schema.table" name, using defaultSchema if name
+// is not qualified.
+func splitTable(name, defaultSchema string) (schema, table string) {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return defaultSchema, name
+}
+
+// tableColumns describes the columns of a table.
+type tableColumns struct {
+	// types holds the data type of every column. Array types are
+	// reported as the element type followed by "[]", e.g. int4[], and
+	// user defined types by their name, e.g. hstore.
+	types map[string]string
+	// notNull holds the NOT NULL columns, required those of them that
+	// have no default and must be given by every row
+	notNull  map[string]bool
+	required []string
+	// scales holds the scale of numeric columns declared with one
+	scales map[string]int
+	// enums holds the labels of enum columns with -check-enums
+	enums map[string][]string
+	// composites holds the fields of composite type columns
+	composites map[string][]compositeField
+}
+
+// columns returns the columns of the table, from the -schema-cache file
+// if one is given.
+func columns(pg *pgx.Conn, dbName, schema, tableName string) (*tableColumns, error) {
+	if schema == "pg_temp" {
+		// temporary tables are listed under the temporary schema of the
+		// session, which only exists once a temporary table was created
+		var err error
+		if schema, err = tempSchema(pg); err != nil {
+			return nil, err
+		}
+		if schema == "" {
+			return &tableColumns{types: make(map[string]string)}, nil
+		}
+		return queryColumns(pg, dbName, schema, tableName)
+	}
+	if *schemaCacheFile != "" {
+		return cachedColumns(pg, dbName, schema, tableName)
+	}
+	return queryColumns(pg, dbName, schema, tableName)
+}
+
+// queryColumns reads the columns of the table from the database.
+func queryColumns(pg *pgx.Conn, dbName, schema, tableName string) (*tableColumns, error) {
 	rows, err := pg.Query(
-		`SELECT column_name, data_type
+		`SELECT column_name,
+			CASE data_type
+				WHEN 'ARRAY' THEN substr(udt_name, 2) || '[]'
+				WHEN 'USER-DEFINED' THEN udt_name
+				ELSE data_type END,
+			is_nullable = 'NO',
+			column_default IS NULL AND is_identity = 'NO' AND is_generated = 'NEVER',
+			CASE WHEN data_type = 'numeric' THEN numeric_scale::int END,
+			data_type = 'USER-DEFINED'
 		FROM information_schema.columns
-		WHERE table_name = $1 AND table_catalog=$2`,
-		tableName, dbName,
+		WHERE table_name = $1 AND table_catalog=$2
+			AND table_schema = $3`,
+		tableName, dbName, schema,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "query failed")
 	}
 	defer rows.Close()
-	cols := make(map[string]string)
+	tc := &tableColumns{
+		types:   make(map[string]string),
+		notNull: make(map[string]bool),
+		scales:  make(map[string]int),
+	}
+	userDefined := false
 	for rows.Next() {
 		var n, t string
-		err = rows.Scan(&n, &t)
+		var notNull, noDefault, udt bool
+		var scale pgtype.Int4
+		err = rows.Scan(&n, &t, &notNull, &noDefault, &scale, &udt)
 		if err != nil {
 			return nil, errors.Wrap(err, "scan failed")
 		}
-		cols[n] = t
+		tc.types[n] = t
+		if notNull {
+			tc.notNull[n] = true
+			if noDefault {
+				tc.required = append(tc.required, n)
+			}
+		}
+		if scale.Status == pgtype.Present {
+			tc.scales[n] = int(scale.Int)
+		}
+		userDefined = userDefined || udt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "query failed")
+	}
+	sort.Strings(tc.required)
+	if userDefined {
+		if tc.composites, err = compositeFields(pg, dbName, schema, tableName); err != nil {
+			return nil, err
+		}
+	}
+	if *checkEnums && len(tc.types) > 0 {
+		if tc.enums, err = enumLabels(pg, schema, tableName); err != nil {
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// tempSchema returns the name of the temporary schema of the session,
+// empty if it has none yet.
+func tempSchema(pg *pgx.Conn) (string, error) {
+	var name string
+	err := pg.QueryRow("SELECT nspname FROM pg_namespace WHERE oid = pg_my_temp_schema()").Scan(&name)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return name, errors.Wrap(err, "temporary schema query failed")
+}
+
+// enumLabels returns the labels of the enum columns of a table in their
+// declared order.
+func enumLabels(pg *pgx.Conn, schema, tableName string) (map[string][]string, error) {
+	rows, err := pg.Query(
+		`SELECT a.attname, e.enumlabel
+		FROM pg_attribute a
+			JOIN pg_class c ON c.oid = a.attrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			JOIN pg_enum e ON e.enumtypid = a.atttypid
+		WHERE c.relname = $1 AND n.nspname = $2
+			AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY a.attname, e.enumsortorder`,
+		tableName, schema,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "enum query failed")
+	}
+	defer rows.Close()
+	enums := make(map[string][]string)
+	for rows.Next() {
+		var col, label string
+		if err := rows.Scan(&col, &label); err != nil {
+			return nil, errors.Wrap(err, "scan failed")
+		}
+		enums[col] = append(enums[col], label)
 	}
-	return cols, nil
+	return enums, rows.Err()
 }
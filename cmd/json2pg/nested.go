@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// nestedTable receives the objects nested under a JSON key of the
+// parent rows, each child row referencing its parent by id.
+type nestedTable struct {
+	*loader
+	// key is the JSON key holding an object or an array of objects
+	key string
+	// name is the table as given on the command line
+	name string
+	// fk is the column of the child table set to the parent id
+	fk string
+}
+
+// nestedSpec is a parsed -nested item.
+type nestedSpec struct {
+	key, table, fk string
+}
+
+// parseNested parses "key:table:fk" items.
+func parseNested(items []string) ([]nestedSpec, error) {
+	specs := make([]nestedSpec, len(items))
+	for i, item := range items {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, errors.Errorf("expected key:table:fk, got %q", item)
+		}
+		specs[i] = nestedSpec{key: parts[0], table: parts[1], fk: parts[2]}
+	}
+	return specs, nil
+}
+
+// addNested inserts a row on its own, returning its id, and then the
+// objects nested in it into their tables. hash is recorded with
+// -track-table.
+func (l *loader) addNested(rowID int, row map[string]interface{}, hash string) error {
+	children := make([]interface{}, len(l.nested))
+	for i, n := range l.nested {
+		children[i] = row[n.key]
+		delete(row, n.key)
+	}
+	r, ok, err := l.prepare(rowID, row)
+	if !ok {
+		return err
+	}
+	r.hash = hash
+	// the parent is referenced by the -returning column, id by default
+	key := *returning
+	if key == "" {
+		key = "id"
+	}
+	q, vals := l.insertQuery([]pendingRow{r})
+	q += " RETURNING " + sqlDialect.quote(key) + "::text"
+	var id string
+	if err := l.queryRow(q, vals, &id); err != nil {
+		return l.failRow(row, rowErrorf(rowID, "Failed to insert row #%d: %s\n\nquery: %s\n\nvals: %+v\n", rowID, describeError(err), q, vals))
+	}
+	// nothing is inserted with -dry-run, the children show a
+	// placeholder for the id
+	switch {
+	case *dryRun:
+	case *returning != "":
+		l.returned([]string{id})
+	default:
+		l.count(1)
+	}
+	for i, n := range l.nested {
+		if err := n.addChildren(rowID, id, children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addChildren inserts v, an object or an array of objects, with fk set
+// to the parent id.
+func (n *nestedTable) addChildren(rowID int, id string, v interface{}) error {
+	var objs []interface{}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		objs = []interface{}{v}
+	case []interface{}:
+		objs = v
+	default:
+		return n.fail(rowErrorf(rowID, "Key %s of row #%d is not an object (%T)\n", n.key, rowID, v))
+	}
+	for _, o := range objs {
+		obj, ok := o.(map[string]interface{})
+		if !ok {
+			if err := n.fail(rowErrorf(rowID, "Key %s of row #%d holds a non object (%T)\n", n.key, rowID, o)); err != nil {
+				return err
+			}
+			continue
+		}
+		if *dryRun {
+			obj[n.fk] = sqlExpr(fmt.Sprintf("/* id of row #%d */ NULL", rowID))
+		} else {
+			obj[n.fk] = id
+		}
+		r, ok, err := n.prepare(rowID, obj)
+		if !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := n.insert(n.session, []pendingRow{r}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newNested sets up the child tables of l.
+func newNested(pg *pgx.Conn, dbName string, l *loader, specs []nestedSpec) error {
+	for _, spec := range specs {
+		schema, table := splitTable(spec.table, *schemaName)
+		tc, err := columns(pg, dbName, schema, table)
+		if err != nil {
+			return errors.Wrapf(err, "table %s", spec.table)
+		}
+		if len(tc.types) == 0 {
+			return errors.Errorf("table %s not found in database %s (schema %s)", table, dbName, schema)
+		}
+		if _, ok := tc.types[spec.fk]; !ok {
+			return errors.Errorf("column %s does not exist in %s", spec.fk, spec.table)
+		}
+		l.nested = append(l.nested, &nestedTable{
+			loader: &loader{
+				session:    l.session,
+				parent:     l,
+				table:      pgx.Identifier{schema, table},
+				cols:       tc.types,
+				notNull:    tc.notNull,
+				required:   tc.required,
+				scales:     tc.scales,
+				enums:      tc.enums,
+				composites: tc.composites,
+			},
+			key:  spec.key,
+			name: spec.table,
+			fk:   spec.fk,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// conditionNames names the SQLSTATE codes rows most commonly fail with,
+// see appendix A of the PostgreSQL documentation.
+var conditionNames = map[string]string{
+	"22001": "string_data_right_truncation",
+	"22003": "numeric_value_out_of_range",
+	"22007": "invalid_datetime_format",
+	"22008": "datetime_field_overflow",
+	"22021": "character_not_in_repertoire",
+	"22023": "invalid_parameter_value",
+	"22P02": "invalid_text_representation",
+	"23502": "not_null_violation",
+	"23503": "foreign_key_violation",
+	"23505": "unique_violation",
+	"23514": "check_violation",
+	"23P01": "exclusion_violation",
+	"40001": "serialization_failure",
+	"40P01": "deadlock_detected",
+	"42703": "undefined_column",
+	"42804": "datatype_mismatch",
+	"54000": "program_limit_exceeded",
+	"57014": "query_canceled",
+}
+
+// describeError formats an error of the server with its SQLSTATE code,
+// the condition name and the violated constraint first, e.g.
+// "23505 unique_violation on orders_pkey: duplicate key value ...".
+// Other errors are returned as is.
+func describeError(err error) string {
+	e, ok := errors.Cause(err).(pgx.PgError)
+	if !ok {
+		return err.Error()
+	}
+	s := e.Code
+	if name, ok := conditionNames[e.Code]; ok {
+		s += " " + name
+	}
+	if e.ConstraintName != "" {
+		s += " on " + e.ConstraintName
+	} else if e.ColumnName != "" {
+		s += " on column " + e.ColumnName
+	}
+	s += ": " + e.Message
+	if e.Detail != "" {
+		s += " (" + e.Detail + ")"
+	}
+	return s
+}
+
+// isNoPartition reports whether err is the error of a partitioned table
+// without a partition for a row. Postgres raises it as a check_violation
+// without a constraint, unlike the violations of CHECK constraints, so
+// it is told apart without matching the message, which is translated
+// with lc_messages.
+func isNoPartition(err error) bool {
+	e, ok := errors.Cause(err).(pgx.PgError)
+	return ok && e.Code == "23514" && e.ConstraintName == ""
+}
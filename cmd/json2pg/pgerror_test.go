@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+func TestIsNoPartition(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no partition", pgx.PgError{Code: "23514", Message: `no partition of relation "events" found for row`, Detail: "Partition key of the failing row contains (at) = (2019-01-01)."}, true},
+		{"translated", pgx.PgError{Code: "23514", Message: `keine Partition von Relation »events« für Zeile gefunden`}, true},
+		{"wrapped", errors.Wrap(pgx.PgError{Code: "23514"}, "insert"), true},
+		{"check constraint", pgx.PgError{Code: "23514", Message: `new row for relation "events" violates check constraint "events_at_check"`, ConstraintName: "events_at_check"}, false},
+		{"other code", pgx.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"}, false},
+		{"not a server error", errors.New("no partition"), false},
+	}
+	for _, tt := range tests {
+		if got := isNoPartition(tt.err); got != tt.want {
+			t.Errorf("%s: isNoPartition(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{pgx.PgError{Code: "23505", Message: "duplicate key value", ConstraintName: "orders_pkey", Detail: "Key (id)=(1) already exists."}, "23505 unique_violation on orders_pkey: duplicate key value (Key (id)=(1) already exists.)"},
+		{pgx.PgError{Code: "23502", Message: "null value", ColumnName: "name"}, "23502 not_null_violation on column name: null value"},
+		{pgx.PgError{Code: "XX000", Message: "internal"}, "XX000: internal"},
+		{errors.New("conn closed"), "conn closed"},
+	}
+	for _, tt := range tests {
+		if got := describeError(tt.err); got != tt.want {
+			t.Errorf("describeError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
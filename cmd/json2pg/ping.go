@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx"
+)
+
+// pingColumn is a column of the table as printed by -ping.
+type pingColumn struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null"`
+}
+
+// pingTable checks that the table exists and may be inserted into, and
+// prints its columns, without reading any input.
+func pingTable(pg *pgx.Conn, config pgx.ConnConfig) error {
+	dbName := config.Database
+	schema, table := splitTable(*tableName, *schemaName)
+	tc, err := columns(pg, dbName, schema, table)
+	if err != nil {
+		return withCode(exitSchema, "Failed to read table structure: %v", err)
+	}
+	if len(tc.types) == 0 {
+		return withCode(exitSchema, "Table %s not found in database %s (schema %s)", table, dbName, schema)
+	}
+	// a hot standby accepts the connection but no insert
+	var insert, readOnly bool
+	name := sqlDialect.table(pgx.Identifier{schema, table})
+	err = pg.QueryRow("SELECT has_table_privilege($1, 'INSERT'), current_setting('transaction_read_only') = 'on'", name).Scan(&insert, &readOnly)
+	if err != nil {
+		return withCode(exitSchema, "Failed to check privileges: %v", err)
+	}
+	cols := make([]pingColumn, 0, len(tc.types))
+	for n, t := range tc.types {
+		cols = append(cols, pingColumn{Name: n, Type: t, NotNull: tc.notNull[n]})
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i].Name < cols[j].Name })
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			Table    string       `json:"table"`
+			Writable bool         `json:"writable"`
+			Columns  []pingColumn `json:"columns"`
+		}{name, insert && !readOnly, cols})
+	} else {
+		fmt.Printf("Connected to %s, table %s has %d columns\n", address(config), name, len(cols))
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, c := range cols {
+			null := ""
+			if c.NotNull {
+				null = "NOT NULL"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Type, null)
+		}
+		w.Flush()
+	}
+	if readOnly {
+		return withCode(exitConfig, "The database is read only")
+	}
+	if !insert {
+		return withCode(exitSchema, "User %s may not insert into %s", config.User, name)
+	}
+	return nil
+}
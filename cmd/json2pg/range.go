@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/pkg/errors"
+)
+
+// rangeType describes a built-in range type: the pgtype value used to
+// send it and the column type its bounds are converted to.
+type rangeType struct {
+	value reflect.Type
+	elem  string
+}
+
+// rangeTypes holds the range column types accepted as an object or a
+// range literal.
+var rangeTypes = map[string]rangeType{
+	"int4range": {reflect.TypeOf(pgtype.Int4range{}), "integer"},
+	"int8range": {reflect.TypeOf(pgtype.Int8range{}), "bigint"},
+	"numrange":  {reflect.TypeOf(pgtype.Numrange{}), "numeric"},
+	"daterange": {reflect.TypeOf(pgtype.Daterange{}), "date"},
+	"tsrange":   {reflect.TypeOf(pgtype.Tsrange{}), "timestamp without time zone"},
+	"tstzrange": {reflect.TypeOf(pgtype.Tstzrange{}), "timestamp with time zone"},
+}
+
+// rangeKeys are the keys of a range given as an object, named after the
+// postgres functions returning them.
+var rangeKeys = map[string]bool{"lower": true, "upper": true, "lower_inc": true, "upper_inc": true}
+
+// toRange converts an object like {"lower": 1, "upper": 10} or a range
+// literal like "[1,10)" or "empty" for a column of the range type rt. A
+// missing or null bound is unbounded. As in postgres, the lower bound is
+// inclusive and the upper one exclusive unless lower_inc or upper_inc
+// say otherwise.
+func toRange(rt rangeType, v interface{}) (interface{}, error) {
+	var lower, upper interface{}
+	lowerType, upperType := pgtype.Inclusive, pgtype.Exclusive
+	switch v := v.(type) {
+	case string:
+		if strings.TrimSpace(v) == "empty" {
+			return newRange(rt, pgtype.Empty, nil, pgtype.Empty, nil)
+		}
+		utr, err := pgtype.ParseUntypedTextRange(v)
+		if err != nil {
+			return nil, errors.Errorf("invalid range %q, expected a literal like [1,10)", v)
+		}
+		lowerType, upperType = utr.LowerType, utr.UpperType
+		if lowerType != pgtype.Unbounded {
+			lower = utr.Lower
+		}
+		if upperType != pgtype.Unbounded {
+			upper = utr.Upper
+		}
+	case map[string]interface{}:
+		var unknown []string
+		for k := range v {
+			if !rangeKeys[k] {
+				unknown = append(unknown, k)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return nil, errors.Errorf("invalid range key %s, expected lower, upper, lower_inc and upper_inc", strings.Join(unknown, ", "))
+		}
+		lower, upper = v["lower"], v["upper"]
+		for _, b := range []struct {
+			key string
+			typ *pgtype.BoundType
+		}{{"lower_inc", &lowerType}, {"upper_inc", &upperType}} {
+			inc, ok := v[b.key]
+			if !ok || inc == nil {
+				continue
+			}
+			isInc, ok := inc.(bool)
+			if !ok {
+				return nil, errors.Errorf("invalid range, %s must be a boolean", b.key)
+			}
+			*b.typ = pgtype.Exclusive
+			if isInc {
+				*b.typ = pgtype.Inclusive
+			}
+		}
+		if lower == nil {
+			lowerType = pgtype.Unbounded
+		}
+		if upper == nil {
+			upperType = pgtype.Unbounded
+		}
+	default:
+		return nil, errors.Errorf("invalid range %v, expected an object or a string", v)
+	}
+	return newRange(rt, lowerType, lower, upperType, upper)
+}
+
+// newRange returns a range value of the type rt with the bounds, which
+// are converted to the type of its elements.
+func newRange(rt rangeType, lowerType pgtype.BoundType, lower interface{}, upperType pgtype.BoundType, upper interface{}) (interface{}, error) {
+	r := reflect.New(rt.value).Elem()
+	for _, b := range []struct {
+		field string
+		typ   pgtype.BoundType
+		v     interface{}
+	}{{"Lower", lowerType, lower}, {"Upper", upperType, upper}} {
+		r.FieldByName(b.field + "Type").Set(reflect.ValueOf(b.typ))
+		if b.v == nil {
+			continue
+		}
+		v, err := coerce(rt.elem, b.v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s bound", strings.ToLower(b.field))
+		}
+		f := r.FieldByName(b.field)
+		if pv := reflect.ValueOf(v); pv.Kind() == reflect.Ptr && pv.Elem().Type() == f.Type() {
+			// coerce returns numerics as pgtype values already
+			f.Set(pv.Elem())
+		} else if err := f.Addr().Interface().(pgtype.Value).Set(v); err != nil {
+			return nil, errors.Wrapf(err, "%s bound", strings.ToLower(b.field))
+		}
+	}
+	r.FieldByName("Status").Set(reflect.ValueOf(pgtype.Present))
+	return r.Addr().Interface(), nil
+}
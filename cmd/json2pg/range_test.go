@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToRange(t *testing.T) {
+	tests := []struct {
+		typ  string
+		v    interface{}
+		want string
+	}{
+		{"int4range", "[1,10)", "[1,10)"},
+		{"int4range", "(1,10]", "(1,10]"},
+		{"int4range", "empty", "empty"},
+		{"int4range", " empty ", "empty"},
+		{"int8range", "[,5)", "(,5)"},
+		{"int8range", "[5,)", "[5,)"},
+		{"int4range", map[string]interface{}{"lower": json.Number("1"), "upper": json.Number("10")}, "[1,10)"},
+		{"int4range", map[string]interface{}{"lower": json.Number("1"), "upper": json.Number("10"), "lower_inc": false, "upper_inc": true}, "(1,10]"},
+		{"int4range", map[string]interface{}{"lower": json.Number("1"), "lower_inc": nil}, "[1,)"},
+		{"int4range", map[string]interface{}{"upper": json.Number("10")}, "(,10)"},
+		{"int4range", map[string]interface{}{"lower": nil, "upper": nil}, "(,)"},
+		{"numrange", map[string]interface{}{"lower": "1.5", "upper": json.Number("2.25")}, "[15e-1,225e-2)"},
+		{"daterange", "[2019-01-01,2019-02-01)", "[2019-01-01,2019-02-01)"},
+		{"daterange", map[string]interface{}{"lower": "2019-01-01"}, "[2019-01-01,)"},
+		{"tstzrange", map[string]interface{}{"lower": "2019-01-01T10:00:00Z", "upper": "2019-01-01T11:00:00Z"}, "[2019-01-01 10:00:00Z,2019-01-01 11:00:00Z)"},
+	}
+	for _, tt := range tests {
+		v, err := coerce(tt.typ, tt.v)
+		if err != nil {
+			t.Errorf("coerce(%s, %v): %v", tt.typ, tt.v, err)
+			continue
+		}
+		if got := text(t, v); got != tt.want {
+			t.Errorf("coerce(%s, %v) = %s, want %s", tt.typ, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestToRangeInvalid(t *testing.T) {
+	tests := []struct {
+		typ string
+		v   interface{}
+	}{
+		{"int4range", "1,10"},
+		{"int4range", "[a,10)"},
+		{"int4range", "[1.5,10)"},
+		{"int4range", map[string]interface{}{"lower": json.Number("1"), "to": json.Number("2")}},
+		{"int4range", map[string]interface{}{"lower": json.Number("1"), "lower_inc": "yes"}},
+		{"int4range", map[string]interface{}{"upper": json.Number("3000000000")}},
+		{"daterange", map[string]interface{}{"lower": "soon"}},
+	}
+	for _, tt := range tests {
+		if v, err := coerce(tt.typ, tt.v); err == nil {
+			t.Errorf("coerce(%s, %v) = %v, want an error", tt.typ, tt.v, text(t, v))
+		}
+	}
+	if _, err := toRange(rangeTypes["int4range"], json.Number("1")); err == nil {
+		t.Error("toRange(int4range, 1): want an error")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// routedTable receives the rows routed to it by the value of the -route
+// field. Rows with other values go to the table of the parent loader.
+type routedTable struct {
+	*loader
+	// name is the table as given on the command line
+	name string
+}
+
+// parseRoutes parses "field:value=table" items, which must all route by
+// the same field, into the field and the tables by value.
+func parseRoutes(items []string) (string, map[string]string, error) {
+	var field string
+	tables := make(map[string]string, len(items))
+	for _, item := range items {
+		parts := strings.SplitN(item, ":", 2)
+		i := strings.LastIndex(item, "=")
+		if len(parts) != 2 || parts[0] == "" || i < len(parts[0]) || i == len(item)-1 {
+			return "", nil, errors.Errorf("expected field:value=table, got %q", item)
+		}
+		if field != "" && parts[0] != field {
+			return "", nil, errors.Errorf("all routes must use the same field, got %s and %s", field, parts[0])
+		}
+		field = parts[0]
+		tables[item[len(field)+1:i]] = item[i+1:]
+	}
+	return field, tables, nil
+}
+
+// newRoutes sets up the tables rows are routed to, reading the columns
+// of each table once. The tables take the column settings and the
+// -on-conflict action of l, which are checked against each of them, and
+// the -set and audit columns they have, the batch column set to batchID.
+func newRoutes(pg *pgx.Conn, dbName string, l *loader, field string, tables map[string]string, batchID string) error {
+	byName := make(map[string]*routedTable)
+	for value, name := range tables {
+		t, ok := byName[name]
+		if !ok {
+			schema, table := splitTable(name, *schemaName)
+			tc, err := columns(pg, dbName, schema, table)
+			if err != nil {
+				return errors.Wrapf(err, "table %s", name)
+			}
+			if len(tc.types) == 0 {
+				return errors.Errorf("table %s not found in database %s (schema %s)", table, dbName, schema)
+			}
+			t = &routedTable{
+				loader: &loader{
+					session:      l.session,
+					parent:       l,
+					table:        pgx.Identifier{schema, table},
+					cols:         tc.types,
+					notNull:      tc.notNull,
+					required:     tc.required,
+					scales:       tc.scales,
+					enums:        tc.enums,
+					composites:   tc.composites,
+					rename:       l.rename,
+					only:         l.only,
+					hints:        l.hints,
+					transforms:   l.transforms,
+					nulls:        l.nulls,
+					onConflict:   l.onConflict,
+					conflictCols: l.conflictCols,
+					routeTarget:  true,
+				},
+				name: name,
+			}
+			if err := checkColumns(t.loader, name); err != nil {
+				return err
+			}
+			if t.set, err = parseSet(t.loader, name, *setValues); err != nil {
+				return usageError("Invalid -set: %v", err)
+			}
+			if err := setAudit(t.loader, name, batchID); err != nil {
+				return usageError("Invalid -audit-batch-column: %v", err)
+			}
+			byName[name] = t
+			l.routed = append(l.routed, t)
+		}
+		if l.routes == nil {
+			l.routes = make(map[string]*routedTable)
+		}
+		l.routes[value] = t
+	}
+	sort.Slice(l.routed, func(i, j int) bool { return l.routed[i].name < l.routed[j].name })
+	l.routeField = field
+	return nil
+}
+
+// route returns the table row is routed to, or nil for the table of l.
+func (l *loader) route(row map[string]interface{}) *routedTable {
+	if l.routes == nil {
+		return nil
+	}
+	var key string
+	switch v := row[l.routeField].(type) {
+	case string:
+		key = v
+	case json.Number:
+		key = v.String()
+	case bool:
+		key = strconv.FormatBool(v)
+	default:
+		return nil
+	}
+	return l.routes[key]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx"
+)
+
+// cacheTables makes columns return the tables from the schema cache, so
+// no database is queried, until the returned func is called.
+func cacheTables(tables map[string]map[string]string) func() {
+	file, cache := *schemaCacheFile, schemaCache
+	*schemaCacheFile = "test-schema-cache.json"
+	schemaCache = make(map[string]*cachedTable)
+	for name, types := range tables {
+		schemaCache[pgx.Identifier{"db", "public", name}.Sanitize()] = &cachedTable{Types: types}
+	}
+	return func() { *schemaCacheFile, schemaCache = file, cache }
+}
+
+func TestNewRoutesConflict(t *testing.T) {
+	defer cacheTables(map[string]map[string]string{
+		"a": {"id": "integer", "kind": "text", "v": "text"},
+		"b": {"kind": "text", "v": "text"},
+	})()
+	db := &fakeDB{}
+	l := newTestLoader(db, map[string]string{"id": "integer", "kind": "text", "v": "text"})
+	l.onConflict, l.conflictCols = "update", []string{"id"}
+	if err := newRoutes(nil, "db", l, "kind", map[string]string{"x": "a"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.load(newTestReader(t, false, `[{"kind":"x","id":1,"v":"y"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.stmts) != 1 || !strings.HasPrefix(db.stmts[0], `INSERT INTO "public"."a" `) || !strings.HasSuffix(db.stmts[0], ` ON CONFLICT ("id") DO UPDATE SET "kind"=EXCLUDED."kind","v"=EXCLUDED."v"`) {
+		t.Errorf("got statements %q, want an upsert into a", db.stmts)
+	}
+
+	l = newTestLoader(db, map[string]string{"id": "integer", "kind": "text", "v": "text"})
+	l.onConflict, l.conflictCols = "nothing", []string{"id"}
+	err := newRoutes(nil, "db", l, "kind", map[string]string{"x": "b"}, "")
+	if want := "Column id given in -conflict-cols does not exist in b"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
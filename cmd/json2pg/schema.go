@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// inferColumns guesses a column type for every key found in rows.
+// Keys that only hold nulls become text.
+func inferColumns(rows []map[string]interface{}) map[string]string {
+	cols := make(map[string]string)
+	for _, row := range rows {
+		for k, v := range row {
+			t := inferType(v)
+			switch prev, seen := cols[k]; {
+			case !seen || prev == "":
+				cols[k] = t
+			case t == "" || t == prev:
+			case t == "jsonb" || prev == "jsonb":
+				cols[k] = "jsonb"
+			default:
+				cols[k] = "text"
+			}
+		}
+	}
+	for k, t := range cols {
+		if t == "" {
+			cols[k] = "text"
+		}
+	}
+	return cols
+}
+
+// inferType returns the column type best matching a decoded JSON value,
+// or an empty string for null.
+func inferType(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "numeric"
+	case string:
+		if _, err := parseTime(v, timestampLayouts); err == nil {
+			return "timestamp with time zone"
+		}
+		return "text"
+	case map[string]interface{}, []interface{}:
+		return "jsonb"
+	}
+	return ""
+}
+
+// createTableQuery returns the CREATE TABLE statement for cols, with the
+// columns in alphabetical order.
+func createTableQuery(table pgx.Identifier, cols map[string]string) string {
+	names := make([]string, 0, len(cols))
+	for k := range cols {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	defs := make([]string, len(names))
+	for i, k := range names {
+		defs[i] = sqlDialect.quote(k) + " " + cols[k]
+	}
+	return "CREATE TABLE " + sqlDialect.table(table) + " (\n\t" + strings.Join(defs, ",\n\t") + "\n)"
+}
+
+// printDDL prints the CREATE TABLE statement for the types inferred from
+// the sampled input rows, for -generate-ddl.
+func printDDL() error {
+	if *tableName == "" {
+		*tableName = tableFromFile(firstInput())
+	}
+	if *tableName == "" {
+		return usageError("Please specify table name")
+	}
+	if *skip < 0 {
+		return usageError("Invalid -skip %d", *skip)
+	}
+	input, closeInputs, err := openRows()
+	if err != nil {
+		return err
+	}
+	defer closeInputs()
+	sample, err := input.Sample(*sampleSize)
+	if err != nil {
+		return fmt.Errorf("Failed to decode input data: %v", err)
+	}
+	rename, err := parsePairs(*columnMap, ":")
+	if err != nil {
+		return usageError("Invalid -map: %v", err)
+	}
+	children, err := parseNested(*nested)
+	if err != nil {
+		return usageError("Invalid -nested: %v", err)
+	}
+	schema, table := targetTable()
+	fmt.Printf("%s;\n", createTableQuery(pgx.Identifier{schema, table}, sampleColumns(sample, rename, children)))
+	return nil
+}
+
+// sampleColumns infers the columns of a new table from sampled rows, the
+// keys taken as they are loaded, after -map and -flatten. The keys of
+// -nested go to the child tables and are left out.
+func sampleColumns(sample []map[string]interface{}, rename map[string]string, children []nestedSpec) map[string]string {
+	l := &loader{rename: rename, cols: map[string]string{}}
+	for _, spec := range children {
+		l.nested = append(l.nested, &nestedTable{key: spec.key})
+	}
+	rows := make([]map[string]interface{}, len(sample))
+	for i, row := range sample {
+		// the sampled rows are loaded later on and must stay as read
+		r := make(map[string]interface{}, len(row))
+		for k, v := range row {
+			r[k] = v
+		}
+		l.reshape(r)
+		for _, n := range l.nested {
+			delete(r, n.key)
+		}
+		rows[i] = make(map[string]interface{}, len(r))
+		for k, v := range r {
+			c, _ := l.column(k)
+			rows[i][c] = v
+		}
+	}
+	return inferColumns(rows)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSampleColumns(t *testing.T) {
+	sample := []map[string]interface{}{
+		{"userId": json.Number("1"), "user": map[string]interface{}{"name": "a", "age": json.Number("3")}, "orders": []interface{}{}},
+	}
+	tests := []struct {
+		name     string
+		flatten  bool
+		rename   map[string]string
+		children []nestedSpec
+		want     map[string]string
+	}{
+		{
+			name:   "renamed",
+			rename: map[string]string{"userId": "user_id"},
+			want:   map[string]string{"user_id": "numeric", "user": "jsonb", "orders": "jsonb"},
+		},
+		{
+			name:   "dotted path",
+			rename: map[string]string{"user.name": "name"},
+			want:   map[string]string{"userId": "numeric", "user": "jsonb", "name": "text", "orders": "jsonb"},
+		},
+		{
+			name:     "flattened",
+			flatten:  true,
+			rename:   map[string]string{"userId": "user_id"},
+			children: []nestedSpec{{key: "user"}},
+			want:     map[string]string{"user_id": "numeric", "orders": "jsonb"},
+		},
+		{
+			name:    "flattened without nested",
+			flatten: true,
+			want:    map[string]string{"userId": "numeric", "user_name": "text", "user_age": "numeric", "orders": "jsonb"},
+		},
+	}
+	defer func(f bool) { *flatten = f }(*flatten)
+	for _, tt := range tests {
+		*flatten = tt.flatten
+		if got := sampleColumns(sample, tt.rename, tt.children); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := sample[0]["user"].(map[string]interface{}); !ok || len(sample[0]) != 3 {
+		t.Errorf("sampled row changed: %v", sample[0])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// cachedTable is the form tableColumns take in the -schema-cache file.
+type cachedTable struct {
+	Types      map[string]string           `json:"types"`
+	NotNull    map[string]bool             `json:"not_null,omitempty"`
+	Required   []string                    `json:"required,omitempty"`
+	Scales     map[string]int              `json:"scales,omitempty"`
+	Enums      map[string][]string         `json:"enums,omitempty"`
+	Composites map[string][]compositeField `json:"composites,omitempty"`
+	// CheckedEnums tells the enum labels were read with -check-enums
+	CheckedEnums bool `json:"checked_enums,omitempty"`
+}
+
+// schemaCache holds the tables of the -schema-cache file by their
+// qualified name, once it is read.
+var schemaCache map[string]*cachedTable
+
+// cachedColumns returns the columns of the table from the -schema-cache
+// file, reading them from the database if the table is not in the file
+// yet or -refresh-schema is set. Tables without columns are not cached
+// so they are looked up again once created.
+func cachedColumns(pg *pgx.Conn, dbName, schema, tableName string) (*tableColumns, error) {
+	if schemaCache == nil {
+		cache, err := readSchemaCache(*schemaCacheFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "schema cache")
+		}
+		schemaCache = cache
+	}
+	key := pgx.Identifier{dbName, schema, tableName}.Sanitize()
+	if c, ok := schemaCache[key]; ok && !*refreshSchema && (c.CheckedEnums || !*checkEnums) {
+		debugf("Using the columns of %s from %s", key, *schemaCacheFile)
+		return &tableColumns{types: c.Types, notNull: c.NotNull, required: c.Required, scales: c.Scales, enums: c.Enums, composites: c.Composites}, nil
+	}
+	tc, err := queryColumns(pg, dbName, schema, tableName)
+	if err != nil || len(tc.types) == 0 {
+		return tc, err
+	}
+	schemaCache[key] = &cachedTable{
+		Types:        tc.types,
+		NotNull:      tc.notNull,
+		Required:     tc.required,
+		Scales:       tc.scales,
+		Enums:        tc.enums,
+		Composites:   tc.composites,
+		CheckedEnums: *checkEnums,
+	}
+	if err := writeSchemaCache(*schemaCacheFile, schemaCache); err != nil {
+		return nil, errors.Wrap(err, "schema cache")
+	}
+	return tc, nil
+}
+
+// readSchemaCache reads the cache file, which does not have to exist.
+func readSchemaCache(name string) (map[string]*cachedTable, error) {
+	cache := make(map[string]*cachedTable)
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	return cache, nil
+}
+
+// writeSchemaCache replaces the cache file, going through a temporary
+// file so a concurrent import never reads half of it.
+func writeSchemaCache(name string, cache map[string]*cachedTable) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sqlExpr is a -set value inserted as an SQL expression, e.g. now(),
+// instead of as a bind parameter.
+type sqlExpr string
+
+// parseSet parses the -set items for the table of l, called name,
+// "column=value" for a literal converted to the column type or
+// "column:=expression" for an SQL expression.
+func parseSet(l *loader, name string, items []string) (map[string]interface{}, error) {
+	set := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		i := strings.Index(item, "=")
+		if i <= 0 {
+			return nil, errors.Errorf("expected column=value or column:=expression, got %q", item)
+		}
+		col, v := item[:i], item[i+1:]
+		expr := strings.HasSuffix(col, ":")
+		col = strings.TrimSuffix(col, ":")
+		typ, ok := l.cols[col]
+		if !ok {
+			return nil, errors.Errorf("column %s does not exist in %s", col, name)
+		}
+		if expr {
+			set[col] = sqlExpr(v)
+			continue
+		}
+		if h, ok := l.hints[col]; ok {
+			typ = h
+		}
+		val, err := coerce(typ, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", col)
+		}
+		set[col] = val
+	}
+	return set, nil
+}
+
+// setColumns returns the -set columns in alphabetical order.
+func (l *loader) setColumns() []string {
+	cols := make([]string, 0, len(l.set))
+	for c := range l.set {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// hasExpr reports whether a -set value is an SQL expression.
+func (l *loader) hasExpr() bool {
+	for _, v := range l.set {
+		if _, ok := v.(sqlExpr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setAudit adds the -audit-file-column and -audit-batch-column columns
+// found in the table of l, called name, to the -set columns of l. The
+// batch column is set to batchID.
+func setAudit(l *loader, name, batchID string) error {
+	if c := *auditFileColumn; c != "" {
+		if _, ok := l.cols[c]; ok {
+			l.auditFile = c
+			l.set[c] = ""
+		} else {
+			infof("Not recording input files, %s has no column %s", name, c)
+		}
+	}
+	if c := *auditBatchColumn; c != "" {
+		typ, ok := l.cols[c]
+		if !ok {
+			infof("Not recording the batch id, %s has no column %s", name, c)
+			return nil
+		}
+		var err error
+		if l.set[c], err = coerce(typ, batchID); err != nil {
+			return errors.Wrapf(err, "%s", c)
+		}
+		infof("Recording batch id %s in column %s of %s", batchID, c, name)
+	}
+	return nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
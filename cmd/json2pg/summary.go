@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// summary is the result of an import as printed by -json-output.
+type summary struct {
+	Inserted   int64            `json:"inserted"`
+	Nested     map[string]int64 `json:"nested,omitempty"`
+	Routed     map[string]int64 `json:"routed,omitempty"`
+	Returned   []string         `json:"returned,omitempty"`
+	Deduped    int64            `json:"deduplicated"`
+	Tracked    int64            `json:"already_loaded"`
+	Commits    int              `json:"commits"`
+	RolledBack bool             `json:"rolled_back"`
+	Elapsed    float64          `json:"elapsed_seconds"`
+	BytesRead  int64            `json:"bytes_read"`
+	RowsPerSec float64          `json:"rows_per_sec"`
+	MBPerSec   float64          `json:"mb_per_sec"`
+	Errors     []summaryError   `json:"errors"`
+	FailedRows []int            `json:"failed_rows"`
+}
+
+// summaryError is an error collected during the import. Row is the
+// index of the input row that caused it, if any.
+type summaryError struct {
+	Row     *int   `json:"row"`
+	Message string `json:"message"`
+}
+
+// report prints the result of the import and returns an error if any
+// errors were collected.
+func report(l *loader) error {
+	if *jsonOutput {
+		printJSON(l)
+	} else {
+		printText(l)
+	}
+	if len(l.errors) > 0 {
+		return errors.Errorf("Import finished with %d errors", len(l.errors))
+	}
+	return nil
+}
+
+func printText(l *loader) {
+	if !*quiet {
+		printTotals(l)
+	}
+	if len(l.errors) > 0 {
+		fmt.Printf("Errors occured during execution (%d):\n", len(l.errors))
+		for i, err := range l.errors {
+			fmt.Printf("#%d\n%s\n", i, err)
+		}
+	}
+}
+
+func printTotals(l *loader) {
+	if *truncate && !*dryRun {
+		fmt.Printf("Truncated %s before loading\n", *tableName)
+	}
+	if *dryRun {
+		fmt.Printf("Dry run, nothing was inserted into %s\n", *tableName)
+	} else {
+		fmt.Printf("Inserted %d rows into %s\n", l.inserted, *tableName)
+		for _, n := range l.nested {
+			fmt.Printf("Inserted %d rows into %s\n", n.inserted, n.name)
+		}
+		for _, t := range l.routed {
+			fmt.Printf("Inserted %d rows into %s\n", t.inserted, t.name)
+		}
+	}
+	if *dedupeOn != "" {
+		fmt.Printf("Skipped %d duplicate rows by %s\n", l.deduped, *dedupeOn)
+	}
+	if l.tracker != nil {
+		fmt.Printf("Skipped %d rows loaded by an earlier run\n", l.tracker.skipped)
+	}
+	elapsed := time.Since(l.started)
+	fmt.Printf("Read %.1f MB in %v (%.0f rows/sec, %.1f MB/sec)\n",
+		float64(l.bytesRead)/1e6, elapsed.Round(time.Millisecond),
+		perSecond(float64(l.inserted), elapsed), perSecond(float64(l.bytesRead)/1e6, elapsed))
+	if len(l.ids) > 0 {
+		fmt.Printf("Returned %s values:\n", *returning)
+		for _, id := range l.ids {
+			fmt.Println(id)
+		}
+	}
+	if l.commits > 1 {
+		fmt.Printf("Committed %d transactions\n", l.commits)
+	} else if l.commits == 1 {
+		fmt.Println("Transaction committed")
+	}
+	if l.rolledBack && l.commits > 0 {
+		fmt.Printf("Last transaction rolled back, keeping the %d rows committed before\n", l.committed)
+	} else if l.rolledBack {
+		fmt.Println("Transaction rolled back, no rows were kept")
+	}
+}
+
+func printJSON(l *loader) {
+	elapsed := time.Since(l.started)
+	s := summary{
+		Inserted:   l.inserted,
+		Elapsed:    elapsed.Seconds(),
+		BytesRead:  l.bytesRead,
+		RowsPerSec: perSecond(float64(l.inserted), elapsed),
+		MBPerSec:   perSecond(float64(l.bytesRead)/1e6, elapsed),
+		Returned:   l.ids,
+		Deduped:    l.deduped,
+		Commits:    l.commits,
+		RolledBack: l.rolledBack,
+		Errors:     make([]summaryError, len(l.errors)),
+		FailedRows: []int{},
+	}
+	if l.tracker != nil {
+		s.Tracked = l.tracker.skipped
+	}
+	failed := make(map[int]bool)
+	for i, err := range l.errors {
+		s.Errors[i].Message = err.Error()
+		if e, ok := err.(*rowError); ok {
+			row := e.row
+			s.Errors[i].Row = &row
+			if !failed[row] {
+				failed[row] = true
+				s.FailedRows = append(s.FailedRows, row)
+			}
+		}
+	}
+	if len(l.nested) > 0 {
+		s.Nested = make(map[string]int64, len(l.nested))
+		for _, n := range l.nested {
+			s.Nested[n.name] += n.inserted
+		}
+	}
+	if len(l.routed) > 0 {
+		s.Routed = make(map[string]int64, len(l.routed))
+		for _, t := range l.routed {
+			s.Routed[t.name] = t.inserted
+		}
+	}
+	sort.Ints(s.FailedRows)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(s)
+}
+
+// perSecond returns the rate of n over d.
+func perSecond(n float64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return n / d.Seconds()
+}
+
+// errorFile writes the rows that failed, one JSON object per line
+// holding the row number, the error and the input row.
+type errorFile struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// errorRecord is a line of the error file.
+type errorRecord struct {
+	Row   *int                   `json:"row"`
+	Error string                 `json:"error"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+func createErrorFile(name string) (*errorFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &errorFile{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (w *errorFile) write(src map[string]interface{}, e error) error {
+	rec := errorRecord{Error: e.Error(), Data: src}
+	if re, ok := e.(*rowError); ok {
+		row := re.row
+		rec.Row = &row
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(rec)
+}
+
+func (w *errorFile) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// tracker records the hash of every inserted row in the -track-table so
+// a re-run of an interrupted import skips the rows already loaded. The
+// hashes of a table are read once up front and kept in memory.
+type tracker struct {
+	table pgx.Identifier
+	// target is the loaded table as stored in the table_name column, quoted
+	// the same whatever the -dialect so recorded hashes stay found
+	target string
+	loaded map[string]bool
+	// skipped counts the rows found in loaded
+	skipped int64
+}
+
+// newTracker creates the tracking table if needed and reads the hashes
+// recorded for the table of l.
+func newTracker(l *loader, name string) (*tracker, error) {
+	schema, table := splitTable(name, *schemaName)
+	t := &tracker{
+		table:  pgx.Identifier{schema, table},
+		target: l.table.Sanitize(),
+		loaded: make(map[string]bool),
+	}
+	q := "CREATE TABLE IF NOT EXISTS " + sqlDialect.table(t.table) + ` (
+	table_name text NOT NULL,
+	hash text NOT NULL,
+	loaded_at timestamp with time zone NOT NULL DEFAULT now(),
+	PRIMARY KEY (table_name, hash)
+)`
+	if _, err := l.exec(q); err != nil {
+		return nil, errors.Wrap(err, "create")
+	}
+	hashes, err := l.queryColumn("SELECT hash FROM "+sqlDialect.table(t.table)+" WHERE table_name = $1", []interface{}{t.target})
+	if err != nil {
+		return nil, errors.Wrap(err, "read hashes")
+	}
+	for _, h := range hashes {
+		t.loaded[h] = true
+	}
+	return t, nil
+}
+
+// rowHash returns the hash identifying a decoded input row. Keys are
+// encoded in sorted order, so the hash does not depend on the order of
+// the keys in the input.
+func rowHash(row map[string]interface{}) string {
+	b, _ := json.Marshal(row)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// seen reports whether the row with hash h was loaded by an earlier run.
+func (t *tracker) seen(h string) bool {
+	if t.loaded[h] {
+		t.skipped++
+		return true
+	}
+	return false
+}
+
+// with returns the WITH clause recording the hashes of batch, run by
+// the INSERT of the rows so either both or neither are stored. The
+// hashes are hex and inlined, leaving the bind parameters to the rows.
+func (t *tracker) with(batch []pendingRow) string {
+	target := "'" + strings.Replace(t.target, "'", "''", -1) + "'"
+	rows := make([]string, len(batch))
+	for i, r := range batch {
+		rows[i] = "(" + target + ",'" + r.hash + "')"
+	}
+	return "WITH tracked AS (INSERT INTO " + sqlDialect.table(t.table) + " (table_name, hash) VALUES " +
+		strings.Join(rows, ",") + " ON CONFLICT DO NOTHING) "
+}
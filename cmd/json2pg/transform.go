@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// transform changes a decoded JSON value before it is coerced to the
+// type of its column.
+type transform func(v interface{}) (interface{}, error)
+
+// parseTransforms parses "column:op" items of -transform, op being
+// uppercase, lowercase, trim, multiply:N or divide:N.
+func parseTransforms(items []string) (map[string][]transform, error) {
+	transforms := make(map[string][]transform)
+	for _, item := range items {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, errors.Errorf("expected column:op, got %q", item)
+		}
+		t, err := newTransform(parts[1], parts[2:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%q", item)
+		}
+		transforms[parts[0]] = append(transforms[parts[0]], t)
+	}
+	return transforms, nil
+}
+
+// parseNullValues parses the -null-value sentinels, either "sentinel"
+// for all columns or "column:sentinel". A sentinel holding a colon is
+// given for all columns with an empty column, e.g. ":12:00".
+func parseNullValues(items []string) map[string]map[string]bool {
+	nulls := make(map[string]map[string]bool)
+	for _, item := range items {
+		col, v := "", item
+		if i := strings.Index(item, ":"); i >= 0 {
+			col, v = item[:i], item[i+1:]
+		}
+		if nulls[col] == nil {
+			nulls[col] = make(map[string]bool)
+		}
+		nulls[col][v] = true
+	}
+	return nulls
+}
+
+// isSentinel reports whether v, a string or a number, is one of the
+// -null-value sentinels of col.
+func isSentinel(nulls map[string]map[string]bool, col string, v interface{}) bool {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	default:
+		return false
+	}
+	return nulls[""][s] || nulls[col][s]
+}
+
+func newTransform(op string, args []string) (transform, error) {
+	switch op {
+	case "uppercase":
+		return stringTransform(strings.ToUpper), nil
+	case "lowercase":
+		return stringTransform(strings.ToLower), nil
+	case "trim":
+		return stringTransform(strings.TrimSpace), nil
+	case "multiply", "divide":
+		if len(args) != 1 {
+			return nil, errors.Errorf("%s needs a number, e.g. %s:100", op, op)
+		}
+		x, ok := new(big.Rat).SetString(args[0])
+		if !ok {
+			return nil, errors.Errorf("invalid number %q", args[0])
+		}
+		if op == "divide" {
+			if x.Sign() == 0 {
+				return nil, errors.New("division by zero")
+			}
+			x.Inv(x)
+		}
+		return func(v interface{}) (interface{}, error) {
+			return scale(v, x)
+		}, nil
+	}
+	return nil, errors.Errorf("unknown op %s", op)
+}
+
+// stringTransform applies fn to string values.
+func stringTransform(fn func(string) string) transform {
+	return func(v interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return fn(v), nil
+		}
+		return nil, errors.Errorf("can not apply a string op to %T", v)
+	}
+}
+
+// maxScaleDigits limits the decimals of a scaled number that is not
+// exact, like a division by 3.
+const maxScaleDigits = 20
+
+// scale multiplies a number, or a string holding one, by x without
+// going through float64.
+func scale(v interface{}, x *big.Rat) (interface{}, error) {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return nil, errors.Errorf("can not scale %T", v)
+	}
+	n, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, errors.Errorf("invalid number %q", s)
+	}
+	n.Mul(n, x)
+	if n.IsInt() {
+		return json.Number(n.Num().String()), nil
+	}
+	d := strings.TrimRight(n.FloatString(maxScaleDigits), "0")
+	return json.Number(d), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// verifier counts the rows of the table after the load to check that the
+// rows reported as inserted are all there.
+type verifier struct {
+	// batch is the -audit-batch-column the count is restricted to,
+	// empty to count the whole table
+	batch string
+	value interface{}
+	// before is the row count of the table before the load
+	before int64
+}
+
+// newVerifier counts the rows of the table before the load, unless the
+// batch column tells the rows of this import apart or the table is
+// truncated first.
+func newVerifier(pg *pgx.Conn, l *loader) (*verifier, error) {
+	v := &verifier{}
+	if c := *auditBatchColumn; c != "" {
+		if val, ok := l.set[c]; ok {
+			v.batch, v.value = c, val
+			return v, nil
+		}
+	}
+	if *onConflict != "" {
+		infof("Not verifying the row count, -on-conflict updates rows without -audit-batch-column")
+		return nil, nil
+	}
+	if *truncate {
+		return v, nil
+	}
+	q := fmt.Sprintf("SELECT count(*) FROM %s", sqlDialect.table(l.table))
+	debugf("%s", q)
+	if err := pg.QueryRow(q).Scan(&v.before); err != nil {
+		return nil, fmt.Errorf("Failed to count rows: %s", describeError(err))
+	}
+	return v, nil
+}
+
+// check counts the rows after the load and fails if they differ from
+// the rows inserted.
+func (v *verifier) check(pg *pgx.Conn, l *loader) error {
+	q := fmt.Sprintf("SELECT count(*) FROM %s", sqlDialect.table(l.table))
+	var args []interface{}
+	if v.batch != "" {
+		q += fmt.Sprintf(" WHERE %s = %s", sqlDialect.quote(v.batch), sqlDialect.placeholder(1))
+		args = append(args, v.value)
+	}
+	debugf("%s", q)
+	var n int64
+	if err := pg.QueryRow(q, args...).Scan(&n); err != nil {
+		return fmt.Errorf("Failed to count rows: %s", describeError(err))
+	}
+	if found := n - v.before; found != l.inserted {
+		return withCode(exitData, "Verification failed: %d rows inserted but %d found in %s", l.inserted, found, sqlDialect.table(l.table))
+	}
+	infof("Verified %d rows in %s", n-v.before, sqlDialect.table(l.table))
+	return nil
+}
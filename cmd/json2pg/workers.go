@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+)
+
+// pool inserts batches concurrently with -workers, each worker on its
+// own connection or sharing a connection pool with -pool.
+type pool struct {
+	batches chan []pendingRow
+	wg      sync.WaitGroup
+
+	// quit is closed when the first worker fails, err is its error
+	quit chan struct{}
+	once sync.Once
+	err  error
+}
+
+// startPool starts a worker for each connection inserting the batches
+// handed over by l.
+func startPool(l *loader, conns []dbConn) *pool {
+	p := &pool{
+		batches: make(chan []pendingRow, len(conns)),
+		quit:    make(chan struct{}),
+	}
+	for _, pg := range conns {
+		s := &session{db: pg, conn: pg}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				select {
+				case batch, ok := <-p.batches:
+					if !ok {
+						return
+					}
+					if err := l.insertBatch(s, batch); err != nil {
+						p.stop(err)
+						return
+					}
+				case <-p.quit:
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// send queues a batch for the next free worker.
+func (p *pool) send(batch []pendingRow) error {
+	select {
+	case p.batches <- batch:
+		return nil
+	case <-p.quit:
+		return p.err
+	}
+}
+
+// stop makes all workers quit after their current batch.
+func (p *pool) stop(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.quit)
+	})
+}
+
+// wait waits for the queued batches to be inserted and returns the
+// error that stopped the workers, if any.
+func (p *pool) wait() error {
+	close(p.batches)
+	p.wg.Wait()
+	select {
+	case <-p.quit:
+		return p.err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// openZip opens the entries of a zip archive whose name, or base name,
+// matches pattern, in the order of their names, which are returned as
+// well. Entries ending with ".gz" are decompressed. The archive has to
+// be closed after the entries.
+func openZip(name, pattern string, gz bool) (io.Closer, []io.ReadCloser, []string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "invalid -entry %q", pattern)
+	}
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var files []*zip.File
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		full, _ := path.Match(pattern, f.Name)
+		base, _ := path.Match(pattern, path.Base(f.Name))
+		if full || base {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	if len(files) == 0 {
+		archive.Close()
+		return nil, nil, nil, errors.Errorf("no entry of %s matches %q", name, pattern)
+	}
+	entries := make([]io.ReadCloser, 0, len(files))
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		r, err := f.Open()
+		if err == nil {
+			r, err = decompress(r, f.Name, gz)
+		}
+		if err != nil {
+			for _, e := range entries {
+				e.Close()
+			}
+			archive.Close()
+			return nil, nil, nil, errors.Wrap(err, f.Name)
+		}
+		entries = append(entries, r)
+		names = append(names, f.Name)
+	}
+	return archive, entries, names, nil
+}